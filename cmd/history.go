@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/config"
+	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/common/source"
+	"github.com/jplein/launchit/pkg/common/state"
+)
+
+const historyUsage = `usage: launchit history <command>
+
+Commands:
+  list         List recorded entries, highest ranked first
+  remove <id>  Remove one or more entries by ID
+  clear        Remove all entries
+  prune        Remove entries that no longer exist, or are excluded in the config
+`
+
+func handleHistory(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, historyUsage)
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = historyList()
+	case "remove":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, historyUsage)
+			os.Exit(1)
+		}
+		err = historyRemove(args[1:])
+	case "clear":
+		err = state.Clear()
+	case "prune":
+		err = historyPrune()
+	default:
+		fmt.Fprint(os.Stderr, historyUsage)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		logger.Log("error running history %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+}
+
+func historyList() error {
+	db, err := state.Load()
+	if err != nil {
+		return err
+	}
+
+	descriptions, _, _ := currentEntries()
+
+	now := time.Now()
+	score := db.Scorer(now, state.Context{})
+	ids := db.IDs()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCORE\tCOUNT\tLAST USED\tID\tDESCRIPTION")
+	for _, id := range ids {
+		desc, ok := descriptions[id]
+		if !ok {
+			desc = "(not found)"
+		}
+
+		u := db.Entries[id]
+		fmt.Fprintf(tw, "%.2f\t%d\t%s\t%s\t%s\n", score(id), u.Count, u.Last().Local().Format("2006-01-02 15:04"), id, desc)
+	}
+
+	return tw.Flush()
+}
+
+func historyRemove(ids []string) error {
+	removed, err := state.Remove(ids...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %d of %d entries\n", removed, len(ids))
+	return nil
+}
+
+// Remove entries that no longer exist (e.g., the .desktop file or command was
+// deleted, or the window was closed), and entries that are excluded in the
+// config. Entries whose source can't say whether they exist are kept.
+func historyPrune() error {
+	db, err := state.Load()
+	if err != nil {
+		return err
+	}
+
+	descriptions, sources, listed := currentEntries()
+	exclusions := config.GetOrDefault().History
+
+	stale := make([]string, 0)
+	for _, id := range db.IDs() {
+		if exclusions.IsExcluded(id) {
+			stale = append(stale, id)
+			continue
+		}
+
+		if _, ok := descriptions[id]; ok {
+			continue
+		}
+
+		s := sources.SourceFor(id)
+
+		// Some sources leave entries out of their list, e.g. hidden
+		// applications, so ask them instead
+		if c, ok := s.(source.ExistenceChecker); ok {
+			exists, err := c.Exists(id)
+			if err != nil {
+				logger.Log("%v\n", err)
+				continue
+			}
+
+			if !exists {
+				stale = append(stale, id)
+			}
+			continue
+		}
+
+		if s != nil && !listed[s.Name()] {
+			continue
+		}
+
+		// Usage recorded under a usage key doesn't match the ID of any listed
+		// entry
+		if _, ok := s.(source.UsageKeyer); ok {
+			continue
+		}
+
+		stale = append(stale, id)
+	}
+
+	for _, id := range stale {
+		fmt.Printf("Removing %s\n", id)
+	}
+
+	return historyRemove(stale)
+}
+
+// Returns the description of every entry currently offered by the sources, by
+// ID, the source set used, and the names of the sources that listed their
+// entries successfully
+func currentEntries() (map[string]string, *source.SourceSet, map[string]bool) {
+	descriptions := make(map[string]string)
+	listed := make(map[string]bool)
+
+	sources, err := source.DefaultSourceSet()
+	if err != nil {
+		logger.Log("error getting sources: %v\n", err)
+		return descriptions, &source.SourceSet{}, listed
+	}
+
+	for _, s := range sources.Sources {
+		entries, err := s.List()
+		if err != nil {
+			logger.Log("error listing %s: %v\n", s.Name(), err)
+			continue
+		}
+
+		listed[s.Name()] = true
+		for _, e := range entries {
+			descriptions[e.ID] = e.Description
+		}
+	}
+
+	return descriptions, sources, listed
+}
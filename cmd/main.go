@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"os"
 	"strconv"
@@ -13,6 +14,7 @@ import (
 	"github.com/jplein/launchit/pkg/common/server"
 	"github.com/jplein/launchit/pkg/common/source"
 	"github.com/jplein/launchit/pkg/common/state"
+	"github.com/jplein/launchit/pkg/common/wm"
 )
 
 // TODO:
@@ -43,7 +45,13 @@ func main() {
 	case "write":
 		writeEntries(args[1:])
 	case "server":
-		startServer()
+		startServer(args[1:])
+	case "history":
+		handleHistory(args[1:])
+	case "overrides":
+		handleOverrides(args[1:])
+	case "open":
+		openWith(args[1:])
 	default:
 		logger.Log("unknown subcommand: %s\n", subcommand)
 		os.Exit(1)
@@ -53,39 +61,54 @@ func main() {
 func writeEntries(args []string) {
 	fs := flag.NewFlagSet("write", flag.ExitOnError)
 	src := fs.String("source", "", "Source to pull entries from")
-	columns := fs.String("columns", "", "Comma-separated list of one or more of name,type. Default vaule is 'name'.")
+	columns := fs.String("columns", "", "Comma-separated list of one or more of name,type,comment,generic. Default vaule is 'name'.")
 	widths := fs.String("widths", "", "Comma-separated list of lengths. Defaults to 0, or no specified width.")
 	icons := fs.Bool("icons", true, "Whether to include icons using the Rofi protocol. Default value is true.")
-	sortRecent := fs.Bool("sort-by-most-recent", true, "Whether to sort by most recent. Default value is true.")
+	iconFormat := fs.String("icon-format", launcher.IconFormatName, "How to write icons: 'name' for icon names, or 'path' for the paths of icon files in the current icon theme. Default value is 'name'.")
+	sortRecent := fs.Bool("sort-by-most-recent", true, "Whether to sort by frecency (how often and how recently each entry was chosen). Default value is true.")
+	showHidden := fs.Bool("show-hidden", false, "Also list applications that are hidden by NoDisplay, TryExec, OnlyShowIn or NotShowIn, with the reason. Bypasses the server. Default value is false.")
 
 	fs.Parse(args)
 
-	sources, err := source.DefaultSourceSet()
+	columnNames, widthInts := parseColumns(*columns, *widths)
+	checkIconFormat(*iconFormat)
+
+	opts := source.Options{ShowHidden: *showHidden}
+
+	var entries []source.Entry
+	var err error
+	if opts.ShowHidden {
+		// The server only keeps the entries that are normally shown
+		entries, err = listEntries(*src, *sortRecent, opts)
+	} else {
+		entries, err = launcher.ListFromServer(*src, *sortRecent)
+		if err != nil {
+			logger.Log("error getting entries from server, listing them directly: %v\n", err)
+
+			entries, err = listEntries(*src, *sortRecent, opts)
+		}
+	}
+
 	if err != nil {
-		logger.Log("error getting launcher: %v", err)
+		logger.Log("error listing entries: %v", err)
 		os.Exit(1)
 	}
 
-	if *src != "" {
-		foundSource := false
-
-		for _, s := range sources.Sources {
-			if s.Name() == *src {
-				sources, err = source.NewSourceSet([]source.Source{s})
-				if err != nil {
-					logger.Log("error getting launcher: %v\n", err)
-					os.Exit(1)
-				}
-				foundSource = true
-			}
-		}
+	if *icons && *iconFormat == launcher.IconFormatPath {
+		launcher.ResolveIconPaths(entries)
+	}
 
-		if !foundSource {
-			logger.Log("error getting launcher: no source with name %s\n", *src)
-		}
+	err = launcher.WriteEntries(os.Stdout, entries, columnNames, widthInts, icons)
+	if err != nil {
+		logger.Log("error writing entries: %v", err)
+		os.Exit(1)
 	}
+}
 
-	columnNames := strings.Split(*columns, ",")
+// Parse the --columns and --widths flags shared by write and open. Exits if
+// either is invalid.
+func parseColumns(columns string, widths string) ([]string, []int) {
+	columnNames := strings.Split(columns, ",")
 	for _, c := range columnNames {
 		if len(c) > 0 && !launcher.IsValidColumnName(c) {
 			logger.Log("Unknown column name '%s', valid values are %s\n", c, strings.Join(launcher.ValidColumnNames(), ", "))
@@ -93,7 +116,7 @@ func writeEntries(args []string) {
 		}
 	}
 
-	widthStrings := strings.Split(*widths, ",")
+	widthStrings := strings.Split(widths, ",")
 	widthInts := make([]int, 0)
 	for _, s := range widthStrings {
 		if s == "" {
@@ -114,17 +137,48 @@ func writeEntries(args []string) {
 		widthInts = append(widthInts, int(w))
 	}
 
-	l, err := launcher.NewLauncher(*sources)
-	if err != nil {
-		logger.Log("error getting launcher: %v", err)
+	return columnNames, widthInts
+}
+
+// Exit if the --icon-format flag is invalid
+func checkIconFormat(format string) {
+	if format != launcher.IconFormatName && format != launcher.IconFormatPath {
+		logger.Log("Unknown icon format '%s', valid values are %s, %s\n", format, launcher.IconFormatName, launcher.IconFormatPath)
 		os.Exit(1)
 	}
+}
 
-	err = l.Write(os.Stdout, columnNames, widthInts, icons, sortRecent)
+// List entries in this process, without the help of the server
+func listEntries(src string, sortRecent bool, opts source.Options) ([]source.Entry, error) {
+	sources, err := source.DefaultSourceSetWithOptions(opts)
 	if err != nil {
-		logger.Log("error writing entries: %v", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("error getting launcher: %w", err)
+	}
+
+	if src != "" {
+		foundSource := false
+
+		for _, s := range sources.Sources {
+			if s.Name() == src {
+				sources, err = source.NewSourceSet([]source.Source{s})
+				if err != nil {
+					return nil, fmt.Errorf("error getting launcher: %w", err)
+				}
+				foundSource = true
+			}
+		}
+
+		if !foundSource {
+			logger.Log("error getting launcher: no source with name %s\n", src)
+		}
+	}
+
+	l, err := launcher.NewLauncher(*sources)
+	if err != nil {
+		return nil, fmt.Errorf("error getting launcher: %w", err)
 	}
+
+	return l.List(sortRecent)
 }
 
 func handleInput() {
@@ -151,7 +205,7 @@ func handleInput() {
 		os.Exit(1)
 	}
 
-	err = state.Add(entry.ID)
+	err = state.Add(sources.UsageKey(entry.ID), launcher.CurrentContext())
 	if err != nil {
 		logger.Log("error writing recent entry %s: %v", entry.ID, err)
 	}
@@ -180,8 +234,19 @@ func readFromSTDIN() (string, error) {
 	return string(buf), nil
 }
 
-func startServer() {
-	err := server.Start()
+func startServer(args []string) {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	tcp := fs.String("tcp", "", "Also listen on this TCP address, e.g. 127.0.0.1:17324. By default the server only listens on a Unix socket in $XDG_RUNTIME_DIR/launchit.")
+
+	fs.Parse(args)
+
+	c, err := wm.Current()
+	if err != nil {
+		logger.Log("error starting server: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = server.Start(c, server.Options{TCPAddress: *tcp})
 	if err != nil {
 		logger.Log("error starting server: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jplein/launchit/pkg/common/launcher"
+	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/common/source"
+)
+
+const openUsage = `usage: launchit open [flags] <file-or-url>...
+
+Writes the applications that can open the files or URLs, the default first, in
+the same format as launchit write. Pipe the launcher's output to launchit read
+to open them with the chosen application.
+
+Flags:
+`
+
+// Write the applications that can open the files and URLs in args
+func openWith(args []string) {
+	fs := flag.NewFlagSet("open", flag.ExitOnError)
+	columns := fs.String("columns", "", "Comma-separated list of one or more of name,type,comment,generic. Default vaule is 'name'.")
+	widths := fs.String("widths", "", "Comma-separated list of lengths. Defaults to 0, or no specified width.")
+	icons := fs.Bool("icons", true, "Whether to include icons using the Rofi protocol. Default value is true.")
+	iconFormat := fs.String("icon-format", launcher.IconFormatName, "How to write icons: 'name' for icon names, or 'path' for the paths of icon files in the current icon theme. Default value is 'name'.")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, openUsage)
+		fs.PrintDefaults()
+	}
+
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	columnNames, widthInts := parseColumns(*columns, *widths)
+	checkIconFormat(*iconFormat)
+
+	// launchit read may run in a different directory, so relative paths are
+	// made absolute
+	uris := make([]string, 0, fs.NArg())
+	for _, arg := range fs.Args() {
+		if !strings.Contains(arg, "://") {
+			abs, err := filepath.Abs(arg)
+			if err != nil {
+				logger.Log("error getting absolute path of %s: %v\n", arg, err)
+				os.Exit(1)
+			}
+			arg = abs
+		}
+
+		uris = append(uris, arg)
+	}
+
+	src := &source.OpenWith{URIs: uris}
+	entries, err := src.List()
+	if err != nil {
+		logger.Log("error listing applications: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(entries) == 0 {
+		logger.Log("no application can open %s\n", strings.Join(uris, ", "))
+		os.Exit(1)
+	}
+
+	// The default application stays first, and the rest are ranked by how
+	// often they've been chosen to open files
+	launcher.SortByUsageKey(entries[1:], src.UsageKey)
+
+	if *icons && *iconFormat == launcher.IconFormatPath {
+		launcher.ResolveIconPaths(entries)
+	}
+
+	err = launcher.WriteEntries(os.Stdout, entries, columnNames, widthInts, icons)
+	if err != nil {
+		logger.Log("error writing entries: %v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/overrides"
+)
+
+const overridesUsage = `usage: launchit overrides <command>
+
+Commands:
+  list                        List the overrides from overrides.yaml, and the learned ones
+  promote <window-app-id>...  Move learned overrides to overrides.yaml
+  reject <window-app-id>...   Stop using learned overrides, and don't learn them again
+`
+
+func handleOverrides(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, overridesUsage)
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = overridesList()
+	case "promote":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, overridesUsage)
+			os.Exit(1)
+		}
+		err = eachWindowAppID(args[1:], overrides.Promote, "Promoted")
+	case "reject":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, overridesUsage)
+			os.Exit(1)
+		}
+		err = eachWindowAppID(args[1:], overrides.Reject, "Rejected")
+	default:
+		fmt.Fprint(os.Stderr, overridesUsage)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		logger.Log("error running overrides %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+}
+
+func overridesList() error {
+	configured, err := overrides.List()
+	if err != nil {
+		return err
+	}
+
+	learned, err := overrides.ListLearned()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tWINDOW APP ID\tAPP ID\tLEARNED")
+	for _, o := range configured {
+		fmt.Fprintf(tw, "configured\t%s\t%s\t\n", o.WindowAppID, o.AppID)
+	}
+
+	for _, l := range learned {
+		status := "learned"
+		if l.Rejected {
+			status = "rejected"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", status, l.WindowAppID, l.AppID, l.LearnedAt.Local().Format("2006-01-02 15:04"))
+	}
+
+	return tw.Flush()
+}
+
+// Call f with each window app ID, stopping at the first error
+//
+// done: What to report for each window app ID, e.g. "Promoted"
+func eachWindowAppID(ids []string, f func(string) error, done string) error {
+	for _, id := range ids {
+		if err := f(id); err != nil {
+			return err
+		}
+
+		fmt.Printf("%s %s\n", done, id)
+	}
+
+	return nil
+}
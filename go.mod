@@ -3,6 +3,6 @@ module github.com/jplein/launchit
 go 1.23.12
 
 require (
-	go.yaml.in/yaml/v4 v4.0.0-rc.2 // indirect
-	gopkg.in/ini.v1 v1.67.0 // indirect
+	go.yaml.in/yaml/v4 v4.0.0-rc.2
+	gopkg.in/ini.v1 v1.67.0
 )
@@ -0,0 +1,140 @@
+package compositor
+
+import "strconv"
+
+// A window as reported by the compositor
+type Window struct {
+	// The compositor's ID for the window. For compositors which identify windows
+	// by something other than an integer (e.g., Hyprland's hex addresses), this
+	// is a lossless conversion of that identifier.
+	ID uint64 `json:"id"`
+
+	Title string `json:"title"`
+
+	// The application ID (Wayland app_id, or X11 class) of the window
+	AppID string `json:"app_id"`
+
+	// The ID of the workspace the window is on, or 0 if unknown
+	WorkspaceID int64 `json:"workspace_id"`
+
+	IsFocused bool `json:"is_focused"`
+	IsUrgent  bool `json:"is_urgent"`
+}
+
+// A workspace as reported by the compositor
+type Workspace struct {
+	ID int64 `json:"id"`
+
+	// The index used to refer to the workspace when switching to it or moving a
+	// window to it, or 0 if it has none (e.g. a named Sway workspace without a
+	// number), in which case it can't be switched to
+	Index int `json:"idx"`
+
+	Name string `json:"name"`
+
+	// The output (monitor) the workspace is on, if known
+	Output string `json:"output"`
+
+	// Whether this is the visible workspace on its output
+	IsActive bool `json:"is_active"`
+
+	// Whether this workspace is on the focused output and active
+	IsFocused bool `json:"is_focused"`
+}
+
+// Returns a name for the workspace that is stable across compositor restarts:
+// its name if it has one, or its index otherwise
+func (w Workspace) Key() string {
+	if w.Name != "" {
+		return w.Name
+	}
+
+	return strconv.Itoa(w.Index)
+}
+
+// An event from the compositor's event stream. Exactly one of the fields is
+// non-nil.
+type Event struct {
+	// The full list of windows, sent when the stream starts and whenever the
+	// compositor can't describe a change more precisely
+	WindowsChanged *WindowsChanged `json:"WindowsChanged,omitempty"`
+
+	// A window was opened, or its properties changed
+	WindowOpenedOrChanged *WindowOpenedOrChanged `json:"WindowOpenedOrChanged,omitempty"`
+
+	WindowClosed *WindowClosed `json:"WindowClosed,omitempty"`
+
+	WindowFocusChanged *WindowFocusChanged `json:"WindowFocusChanged,omitempty"`
+
+	WindowUrgencyChanged *WindowUrgencyChanged `json:"WindowUrgencyChanged,omitempty"`
+
+	// The full list of workspaces, sent when the stream starts and whenever
+	// workspaces are created, removed or change
+	WorkspacesChanged *WorkspacesChanged `json:"WorkspacesChanged,omitempty"`
+
+	// A workspace became active on its output
+	WorkspaceActivated *WorkspaceActivated `json:"WorkspaceActivated,omitempty"`
+}
+
+type WindowsChanged struct {
+	Windows []Window `json:"windows"`
+}
+
+type WindowOpenedOrChanged struct {
+	Window Window `json:"window"`
+}
+
+type WindowClosed struct {
+	ID uint64 `json:"id"`
+}
+
+type WindowFocusChanged struct {
+	// The newly-focused window, or nil if no window has focus
+	ID *uint64 `json:"id"`
+}
+
+type WindowUrgencyChanged struct {
+	ID     uint64 `json:"id"`
+	Urgent bool   `json:"urgent"`
+}
+
+type WorkspacesChanged struct {
+	Workspaces []Workspace `json:"workspaces"`
+}
+
+type WorkspaceActivated struct {
+	ID int64 `json:"id"`
+
+	// Whether the workspace also became the focused workspace
+	Focused bool `json:"focused"`
+}
+
+// A stream of events from the compositor
+type EventStream interface {
+	// Block until the next event is available. Returns an error if the stream
+	// is broken; the caller should close it and open a new one.
+	Next() (Event, error)
+
+	Close() error
+}
+
+// A running compositor (window manager) that launchit can query and control
+type Compositor interface {
+	// A human-readable name for the compositor, e.g., "Niri"
+	Name() string
+
+	ListWindows() ([]Window, error)
+
+	FocusWindow(id uint64) error
+
+	ListWorkspaces() ([]Workspace, error)
+
+	// Switch to the workspace with the given index, as in Workspace.Index
+	SwitchWorkspace(index int) error
+
+	// Move the focused window to the workspace with the given index
+	MoveWindowToWorkspace(index int) error
+
+	// Open a new subscription to the compositor's events
+	EventStream() (EventStream, error)
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/common/state/locations"
+	"go.yaml.in/yaml/v4"
+)
+
+const (
+	defaultHalfLife      = 72 * time.Hour
+	defaultContextWeight = 1.0
+	defaultIconSize      = 48
+)
+
+type Config struct {
+	Ranking Ranking `yaml:"ranking"`
+	History History `yaml:"history"`
+	Icons   Icons   `yaml:"icons"`
+	Launch  Launch  `yaml:"launch"`
+}
+
+type Ranking struct {
+	// A Go duration string, e.g. "72h"
+	HalfLife string `yaml:"half-life"`
+
+	// How much to boost entries chosen in the current context. Nil means the
+	// default.
+	ContextWeight *float64 `yaml:"context-weight"`
+}
+
+type History struct {
+	// Patterns of entry IDs that are never recorded. * matches any sequence of
+	// characters (including /), and ? matches any single character.
+	Exclude []string `yaml:"exclude"`
+}
+
+type Icons struct {
+	// The icon theme to look icons up in. Empty means the theme from the GTK
+	// settings.
+	Theme string `yaml:"theme"`
+
+	// The preferred icon size, in pixels. 0 means the default.
+	Size int `yaml:"size"`
+}
+
+type Launch struct {
+	// How to start applications and commands: setsid, systemd-run or uwsm.
+	// Empty means setsid.
+	Method string `yaml:"method"`
+
+	// The terminal to run applications with Terminal=true in, with any
+	// arguments, e.g. "kitty --single-instance". Empty means detect it.
+	Terminal string `yaml:"terminal"`
+}
+
+// Returns the configured icon size, or the default if it isn't set or is
+// invalid
+func (i Icons) SizeValue() int {
+	if i.Size == 0 {
+		return defaultIconSize
+	}
+
+	if i.Size < 0 {
+		logger.Log("invalid icons size %d in config, using %d\n", i.Size, defaultIconSize)
+		return defaultIconSize
+	}
+
+	return i.Size
+}
+
+// Returns true if the entry ID matches one of the exclusion patterns
+func (h History) IsExcluded(id string) bool {
+	for _, pattern := range h.Exclude {
+		if globMatch(pattern, id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func globMatch(pattern string, s string) bool {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+
+	return regexp.MustCompile("^" + quoted + "$").MatchString(s)
+}
+
+//go:embed res/config.yaml
+var configBuf []byte
+
+const (
+	// Path to the config file, relative to the XDG config directory
+	configFile = "config.yaml"
+)
+
+var (
+	cached    *Config
+	cachedErr error
+	readOnce  sync.Once
+)
+
+// Returns the configuration, reading it on the first call. If the file is
+// missing, it is created with the default configuration.
+func Get() (*Config, error) {
+	readOnce.Do(func() {
+		cached, cachedErr = read()
+	})
+
+	return cached, cachedErr
+}
+
+// Returns the configuration, or the defaults if it can't be read
+func GetOrDefault() *Config {
+	c, err := Get()
+	if err != nil {
+		logger.Log("error reading config, using defaults: %v\n", err)
+		return &Config{}
+	}
+
+	return c
+}
+
+func read() (*Config, error) {
+	configPath, err := locations.Initialize(locations.XDGConfigDir, configFile, configBuf, locations.DefaultFilePermission)
+	if err != nil {
+		return nil, fmt.Errorf("error getting config: %w", err)
+	}
+
+	buf, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("error getting config: error reading from %s: %w", configPath, err)
+	}
+
+	var c Config
+	if err := yaml.Unmarshal(buf, &c); err != nil {
+		return nil, fmt.Errorf("error reading config: error parsing YAML: %w", err)
+	}
+
+	return &c, nil
+}
+
+// Returns the configured half-life for launch history, or the default if it
+// isn't set or is invalid
+func (r Ranking) HalfLifeDuration() time.Duration {
+	if r.HalfLife == "" {
+		return defaultHalfLife
+	}
+
+	d, err := time.ParseDuration(r.HalfLife)
+	if err != nil || d <= 0 {
+		logger.Log("invalid ranking half-life '%s' in config, using %v\n", r.HalfLife, defaultHalfLife)
+		return defaultHalfLife
+	}
+
+	return d
+}
+
+// Returns the configured context weight, or the default if it isn't set or is
+// negative
+func (r Ranking) ContextWeightValue() float64 {
+	if r.ContextWeight == nil {
+		return defaultContextWeight
+	}
+
+	if *r.ContextWeight < 0 {
+		logger.Log("invalid ranking context-weight %v in config, using %v\n", *r.ContextWeight, defaultContextWeight)
+		return defaultContextWeight
+	}
+
+	return *r.ContextWeight
+}
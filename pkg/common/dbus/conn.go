@@ -0,0 +1,260 @@
+package dbus
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long to wait for a reply. Activating an application may start it, so
+// this is as long as libdbus's default.
+const callTimeout = 25 * time.Second
+
+// A connection to a message bus. It only makes method calls, one at a time,
+// which is all launchit needs.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	serial uint32
+}
+
+// An error reply to a method call
+type Error struct {
+	// The error name, e.g. org.freedesktop.DBus.Error.ServiceUnknown
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+
+	return e.Name + ": " + e.Message
+}
+
+// Connect to the session bus at $DBUS_SESSION_BUS_ADDRESS, or at
+// $XDG_RUNTIME_DIR/bus if it isn't set
+func SessionBus() (*Conn, error) {
+	address := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if address == "" {
+		runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+		if runtimeDir == "" {
+			return nil, errors.New("error connecting to the session bus: neither DBUS_SESSION_BUS_ADDRESS nor XDG_RUNTIME_DIR is set")
+		}
+		address = "unix:path=" + path.Join(runtimeDir, "bus")
+	}
+
+	return Dial(address)
+}
+
+// Connect to the bus at a D-Bus server address, e.g. unix:path=/run/user/1000/bus.
+// Only unix transports are supported. If the address lists several, each is
+// tried in turn.
+func Dial(address string) (*Conn, error) {
+	var errs []error
+	for _, a := range strings.Split(address, ";") {
+		if a == "" {
+			continue
+		}
+
+		socket, err := unixSocket(a)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		conn, err := net.DialTimeout("unix", socket, callTimeout)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error connecting to the bus at %s: %w", a, err))
+			continue
+		}
+
+		c := &Conn{conn: conn, reader: bufio.NewReader(conn)}
+		if err := c.authenticate(); err != nil {
+			conn.Close()
+			errs = append(errs, fmt.Errorf("error connecting to the bus at %s: %w", a, err))
+			continue
+		}
+
+		if err := c.hello(); err != nil {
+			conn.Close()
+			errs = append(errs, fmt.Errorf("error connecting to the bus at %s: %w", a, err))
+			continue
+		}
+
+		return c, nil
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("error connecting to the bus: no address in '%s'", address)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// Returns the socket to dial for a unix: address. Abstract sockets start with
+// @, as the net package expects.
+func unixSocket(address string) (string, error) {
+	transport, params, _ := strings.Cut(address, ":")
+	if transport != "unix" {
+		return "", fmt.Errorf("error connecting to the bus at %s: unsupported transport %s", address, transport)
+	}
+
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(param, "=")
+		value, err := url.PathUnescape(value)
+		if err != nil {
+			return "", fmt.Errorf("error connecting to the bus at %s: invalid value for %s: %w", address, key, err)
+		}
+
+		switch key {
+		case "path":
+			return value, nil
+		case "abstract":
+			return "@" + value, nil
+		}
+	}
+
+	return "", fmt.Errorf("error connecting to the bus at %s: no path to connect to", address)
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Authenticate as the user running launchit with the EXTERNAL mechanism
+func (c *Conn) authenticate() error {
+	c.conn.SetDeadline(time.Now().Add(callTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+
+	// The bus reads the credentials from the first byte, which must be nul
+	if _, err := io.WriteString(c.conn, "\x00AUTH EXTERNAL "+uid+"\r\n"); err != nil {
+		return fmt.Errorf("error authenticating: %w", err)
+	}
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("error authenticating: %w", err)
+	}
+
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("error authenticating: bus replied '%s'", strings.TrimSpace(line))
+	}
+
+	if _, err := io.WriteString(c.conn, "BEGIN\r\n"); err != nil {
+		return fmt.Errorf("error authenticating: %w", err)
+	}
+
+	return nil
+}
+
+// Register with the bus, which must be the first call on a connection
+func (c *Conn) hello() error {
+	_, err := c.call(call{
+		Destination: "org.freedesktop.DBus",
+		Path:        "/org/freedesktop/DBus",
+		Interface:   "org.freedesktop.DBus",
+		Member:      "Hello",
+	})
+
+	return err
+}
+
+// Call a method and wait for it to return. Returns an *Error if the method
+// returned an error.
+//
+// signature: The signature of the arguments in body, e.g. "a{sv}", or "" if
+// there are none
+//
+// body: The arguments, written with an Encoder
+func (c *Conn) Call(destination string, objectPath string, iface string, member string, signature string, body []byte) error {
+	_, err := c.call(call{
+		Destination: destination,
+		Path:        objectPath,
+		Interface:   iface,
+		Member:      member,
+		Signature:   signature,
+		Body:        body,
+	})
+	if err != nil {
+		return fmt.Errorf("error calling %s.%s on %s: %w", iface, member, destination, err)
+	}
+
+	return nil
+}
+
+// Send a method call, and return its reply. Signals and other messages that
+// arrive in the meantime are skipped.
+func (c *Conn) call(m call) (*message, error) {
+	c.conn.SetDeadline(time.Now().Add(callTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	c.serial++
+	serial := c.serial
+
+	if _, err := c.conn.Write(m.marshal(serial)); err != nil {
+		return nil, err
+	}
+
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+
+		if reply.ReplySerial != serial {
+			continue
+		}
+
+		switch reply.Type {
+		case typeMethodReturn:
+			return reply, nil
+		case typeError:
+			return nil, &Error{Name: reply.ErrorName, Message: reply.errorMessage()}
+		}
+	}
+}
+
+// Read the next message
+func (c *Conn) read() (*message, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, fixed); err != nil {
+		return nil, err
+	}
+
+	length, order, err := messageLength(fixed)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, length)
+	copy(buf, fixed)
+	if _, err := io.ReadFull(c.reader, buf[16:]); err != nil {
+		return nil, err
+	}
+
+	return unmarshal(buf, order)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
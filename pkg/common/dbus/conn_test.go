@@ -0,0 +1,149 @@
+package dbus
+
+import (
+	"bufio"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The name the echo service owns on the test bus
+const echoName = "org.example.Echo"
+
+// Start a private session bus, with a service that replies to every call, and
+// point DBUS_SESSION_BUS_ADDRESS at it
+func startBus(t *testing.T) {
+	t.Helper()
+
+	for _, tool := range []string{"dbus-daemon", "dbus-test-tool"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s isn't installed", tool)
+		}
+	}
+
+	daemon := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address")
+	stdout, err := daemon.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.Start(); err != nil {
+		t.Skipf("error starting dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		daemon.Process.Kill()
+		daemon.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Skipf("error reading the bus address from dbus-daemon: %v", err)
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", strings.TrimSpace(address))
+
+	echo := exec.Command("dbus-test-tool", "echo", "--name="+echoName)
+	if err := echo.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		echo.Process.Kill()
+		echo.Wait()
+	})
+
+	conn, err := SessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Wait for the service to own its name
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := conn.Call(echoName, "/", "org.freedesktop.DBus.Peer", "Ping", "", nil)
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("echo service didn't start: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCall(t *testing.T) {
+	startBus(t)
+
+	conn, err := SessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var body Encoder
+	body.String("action")
+	body.Array(1, func() {})
+	body.StringDict(map[string]string{"activation-token": "token", "desktop-startup-id": "id"})
+
+	if err := conn.Call(echoName, "/org/example/Echo", "org.example.Echo", "Method", "sava{sv}", body.Bytes()); err != nil {
+		t.Fatalf("error calling the echo service: %v", err)
+	}
+
+	// The connection is still usable after a call
+	if err := conn.Call(echoName, "/", "org.freedesktop.DBus.Peer", "Ping", "", nil); err != nil {
+		t.Fatalf("error calling the echo service a second time: %v", err)
+	}
+}
+
+func TestCallUnknownService(t *testing.T) {
+	startBus(t)
+
+	conn, err := SessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	err = conn.Call("org.example.Missing", "/org/example/Missing", "org.example.Missing", "Method", "", nil)
+
+	var dbusErr *Error
+	if !errors.As(err, &dbusErr) {
+		t.Fatalf("calling a missing service returned %v, want an *Error", err)
+	}
+	if dbusErr.Name != "org.freedesktop.DBus.Error.ServiceUnknown" {
+		t.Errorf("calling a missing service returned error %s, want org.freedesktop.DBus.Error.ServiceUnknown", dbusErr.Name)
+	}
+	if dbusErr.Message == "" {
+		t.Errorf("error %s has no message", dbusErr.Name)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+		wantErr bool
+	}{
+		{address: "unix:path=/run/user/1000/bus", want: "/run/user/1000/bus"},
+		{address: "unix:path=/tmp/dbus%20bus,guid=1234", want: "/tmp/dbus bus"},
+		{address: "unix:guid=1234,abstract=/tmp/dbus-abc", want: "@/tmp/dbus-abc"},
+		{address: "tcp:host=localhost,port=1234", wantErr: true},
+		{address: "unix:guid=1234", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := unixSocket(test.address)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("unixSocket(%q) = %q, want an error", test.address, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unixSocket(%q) returned error: %v", test.address, err)
+		} else if got != test.want {
+			t.Errorf("unixSocket(%q) = %q, want %q", test.address, got, test.want)
+		}
+	}
+}
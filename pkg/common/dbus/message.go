@@ -0,0 +1,335 @@
+package dbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Message types
+const (
+	typeMethodCall   = 1
+	typeMethodReturn = 2
+	typeError        = 3
+)
+
+// Header field codes
+const (
+	fieldPath        = 1
+	fieldInterface   = 2
+	fieldMember      = 3
+	fieldErrorName   = 4
+	fieldReplySerial = 5
+	fieldDestination = 6
+	fieldSignature   = 8
+)
+
+// Message length limit from the specification
+const maxMessageLength = 128 * 1024 * 1024
+
+// Writes values in the D-Bus wire format, little-endian. Alignment is relative
+// to the start of the buffer, so a body must be encoded with its own Encoder.
+type Encoder struct {
+	buf []byte
+}
+
+// Returns the encoded values
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+func (e *Encoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *Encoder) Byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *Encoder) Uint32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+// Write a string or an object path
+func (e *Encoder) String(s string) {
+	e.Uint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+func (e *Encoder) Signature(s string) {
+	e.Byte(byte(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+// Write an array, calling f to write the elements
+//
+// alignment: The alignment of the element type, e.g. 8 for structs and dict
+// entries, 4 for strings, 1 for variants
+func (e *Encoder) Array(alignment int, f func()) {
+	e.Uint32(0)
+	lengthAt := len(e.buf) - 4
+
+	e.align(alignment)
+	start := len(e.buf)
+	f()
+
+	binary.LittleEndian.PutUint32(e.buf[lengthAt:], uint32(len(e.buf)-start))
+}
+
+// Start a struct or a dict entry
+func (e *Encoder) Struct() {
+	e.align(8)
+}
+
+// Write an a{sv} dictionary of string values, e.g. platform data. Keys are
+// written in sorted order.
+func (e *Encoder) StringDict(values map[string]string) {
+	e.Array(8, func() {
+		for _, key := range sortedKeys(values) {
+			e.Struct()
+			e.String(key)
+			e.Signature("s")
+			e.String(values[key])
+		}
+	})
+}
+
+// Write an as array
+func (e *Encoder) StringArray(values []string) {
+	e.Array(4, func() {
+		for _, v := range values {
+			e.String(v)
+		}
+	})
+}
+
+// A method call to send
+type call struct {
+	Destination string
+	Path        string
+	Interface   string
+	Member      string
+	Signature   string
+	Body        []byte
+}
+
+// Encode a method call with the given serial
+func (c call) marshal(serial uint32) []byte {
+	var e Encoder
+	e.Byte('l')
+	e.Byte(typeMethodCall)
+	e.Byte(0)
+	e.Byte(1)
+	e.Uint32(uint32(len(c.Body)))
+	e.Uint32(serial)
+
+	e.Array(8, func() {
+		field := func(code byte, signature string, value string) {
+			e.Struct()
+			e.Byte(code)
+			e.Signature(signature)
+			if signature == "g" {
+				e.Signature(value)
+			} else {
+				e.String(value)
+			}
+		}
+
+		field(fieldPath, "o", c.Path)
+		if c.Interface != "" {
+			field(fieldInterface, "s", c.Interface)
+		}
+		field(fieldMember, "s", c.Member)
+		if c.Destination != "" {
+			field(fieldDestination, "s", c.Destination)
+		}
+		if c.Signature != "" {
+			field(fieldSignature, "g", c.Signature)
+		}
+	})
+
+	e.align(8)
+
+	return append(e.buf, c.Body...)
+}
+
+// The parts of a received message that replies are matched and checked with
+type message struct {
+	Type        byte
+	ReplySerial uint32
+	ErrorName   string
+	Signature   string
+	Body        []byte
+	order       binary.ByteOrder
+}
+
+// Returns the length of the message that starts with the 16-byte fixed
+// header, including its body
+func messageLength(fixed []byte) (int, binary.ByteOrder, error) {
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return 0, nil, fmt.Errorf("error reading D-Bus message: invalid byte order '%c'", fixed[0])
+	}
+
+	bodyLength := int(order.Uint32(fixed[4:]))
+	fieldsLength := int(order.Uint32(fixed[12:]))
+	headerLength := 16 + fieldsLength
+	headerLength += (8 - headerLength%8) % 8
+
+	length := headerLength + bodyLength
+	if length > maxMessageLength || bodyLength < 0 || fieldsLength < 0 {
+		return 0, nil, errors.New("error reading D-Bus message: message is too long")
+	}
+
+	return length, order, nil
+}
+
+// Decode a whole message, as sized by messageLength
+func unmarshal(buf []byte, order binary.ByteOrder) (*message, error) {
+	m := &message{Type: buf[1], order: order}
+
+	fieldsLength := int(order.Uint32(buf[12:]))
+	d := decoder{buf: buf[:16+fieldsLength], offset: 16, order: order}
+
+	for d.offset < len(d.buf) {
+		d.align(8)
+		code, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		signature, err := d.signature()
+		if err != nil {
+			return nil, err
+		}
+
+		switch signature {
+		case "s", "o":
+			s, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			if code == fieldErrorName {
+				m.ErrorName = s
+			}
+		case "g":
+			s, err := d.signature()
+			if err != nil {
+				return nil, err
+			}
+			if code == fieldSignature {
+				m.Signature = s
+			}
+		case "u":
+			v, err := d.uint32()
+			if err != nil {
+				return nil, err
+			}
+			if code == fieldReplySerial {
+				m.ReplySerial = v
+			}
+		default:
+			return nil, fmt.Errorf("error reading D-Bus message: unexpected header field type %s", signature)
+		}
+	}
+
+	headerLength := 16 + fieldsLength
+	headerLength += (8 - headerLength%8) % 8
+	m.Body = buf[headerLength:]
+
+	return m, nil
+}
+
+// Returns the error message of an error reply, which is its first argument if
+// that is a string
+func (m *message) errorMessage() string {
+	if len(m.Signature) == 0 || m.Signature[0] != 's' {
+		return ""
+	}
+
+	d := decoder{buf: m.Body, order: m.order}
+	s, err := d.string()
+	if err != nil {
+		return ""
+	}
+
+	return s
+}
+
+// Reads values in the D-Bus wire format
+type decoder struct {
+	buf    []byte
+	offset int
+	order  binary.ByteOrder
+}
+
+var errTruncated = errors.New("error reading D-Bus message: message is truncated")
+
+func (d *decoder) align(n int) {
+	d.offset += (n - d.offset%n) % n
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.offset >= len(d.buf) {
+		return 0, errTruncated
+	}
+
+	b := d.buf[d.offset]
+	d.offset++
+
+	return b, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	d.align(4)
+	if d.offset+4 > len(d.buf) {
+		return 0, errTruncated
+	}
+
+	v := d.order.Uint32(d.buf[d.offset:])
+	d.offset += 4
+
+	return v, nil
+}
+
+func (d *decoder) string() (string, error) {
+	length, err := d.uint32()
+	if err != nil {
+		return "", err
+	}
+
+	if d.offset+int(length)+1 > len(d.buf) {
+		return "", errTruncated
+	}
+
+	s := string(d.buf[d.offset : d.offset+int(length)])
+	d.offset += int(length) + 1
+
+	return s, nil
+}
+
+func (d *decoder) signature() (string, error) {
+	length, err := d.byte()
+	if err != nil {
+		return "", err
+	}
+
+	if d.offset+int(length)+1 > len(d.buf) {
+		return "", errTruncated
+	}
+
+	s := string(d.buf[d.offset : d.offset+int(length)])
+	d.offset += int(length) + 1
+
+	return s, nil
+}
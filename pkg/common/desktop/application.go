@@ -3,8 +3,10 @@ package desktop
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/jplein/launchit/pkg/common/logger"
@@ -16,17 +18,137 @@ const (
 	defaultXDGDataHome = "~/.local/share"
 )
 
+// Options for reading desktop files, which are close to, but not quite, INI
+// files
+var loadOptions = ini.LoadOptions{
+	// Lists are separated by semicolons, which would otherwise start a comment
+	IgnoreInlineComment: true,
+
+	// Quotes in Exec lines are significant, even around the whole value
+	PreserveSurroundedQuote: true,
+
+	// A trailing backslash doesn't continue the value on the next line
+	IgnoreContinuation: true,
+
+	// Values may contain colons, e.g. in URLs
+	KeyValueDelimiters: "=",
+}
+
 type App struct {
-	Icon     string
-	Name     string
+	Icon string
+
+	// The name, generic name (e.g. "Web Browser"), comment and keywords, in the
+	// current locale if the desktop file has a translation
+	Name        string
+	GenericName string
+	Comment     string
+	Keywords    []string
+
+	// The desktop file ID without the .desktop suffix, e.g. firefox, or kde-foo
+	// for applications/kde/foo.desktop
 	ID       string
 	Filename string
-	Exec     string
-	Path     string
+
+	// The Exec line, still quoted and with field codes in place. Use Argv to
+	// get the arguments to run.
+	Exec string
+	Path string
+
+	// Whether the application runs in a terminal, from the Terminal key
+	Terminal bool
+
+	// Whether the application is started by calling it over D-Bus rather than
+	// by running Exec, from the DBusActivatable key. Exec may be empty if so.
+	DBusActivatable bool
+
+	// MIME types the application can open, from the MimeType key
+	MimeTypes []string
+
+	// Additional actions the application offers, like opening a new private
+	// window, in the order listed in the desktop file
+	Actions []Action
+
+	// Whether the application should be left out of menus, though it may still
+	// open files, from the NoDisplay key
+	NoDisplay bool
+
+	// Whether the desktop file has been deleted, from the Hidden key. Hidden
+	// applications aren't returned by List, and hide the applications with
+	// the same ID in directories of lower precedence.
+	Hidden bool
+
+	// A program that must be installed for the application to be shown, from
+	// the TryExec key
+	TryExec string
+
+	// The WM class (X11) or app ID (Wayland) of the application's windows, if it
+	// differs from the ID, from the StartupWMClass key
+	StartupWMClass string
+
+	// The Flatpak application ID, for applications exported by Flatpak, from the
+	// X-Flatpak key
+	FlatpakID string
+
+	// The desktops the application is shown in, and the desktops it isn't shown
+	// in, from the OnlyShowIn and NotShowIn keys
+	OnlyShowIn []string
+	NotShowIn  []string
+
+	// Whether the desktop file sets an Icon, rather than Icon being the
+	// default. %i expands to nothing without one.
+	hasIcon bool
 }
 
+// An action from a [Desktop Action <id>] group
+type Action struct {
+	// The action identifier, as listed in the Actions key
+	ID   string
+	Name string
+
+	// The action's icon, or the application's if the action doesn't set one
+	Icon string
+
+	// The Exec line, still quoted and with field codes in place. Use
+	// App.ActionArgv to get the arguments to run.
+	Exec string
+}
+
+// Returns the desktop file ID, as used in mimeapps.list, e.g. firefox.desktop
+func (a App) DesktopFileID() string {
+	return a.ID + ".desktop"
+}
+
+// Returns the action with the given ID, or nil if the application has no such
+// action
+func (a App) Action(id string) *Action {
+	for _, action := range a.Actions {
+		if action.ID == id {
+			return &action
+		}
+	}
+
+	return nil
+}
+
+// Returns the installed applications, from the desktop database. When more
+// than one directory has a desktop file with the same ID, only the one in the
+// directory with the highest precedence is used, and applications it marks as
+// Hidden are left out.
 func List() ([]App, error) {
-	dirs, err := getSearchDirs()
+	db, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return db.Apps(), nil
+}
+
+// Read every desktop file in the application directories, following the
+// precedence rules described for List
+//
+// parser: Parses each file, reusing earlier results for unchanged files
+func scan(parser *fileParser) ([]App, error) {
+	dirs, err := SearchDirs()
 	if err != nil {
 		return nil, err
 	}
@@ -42,84 +164,178 @@ func List() ([]App, error) {
 		}
 
 		for _, file := range files {
-			app, err := FromFile(file)
+			id := desktopFileID(dir, file)
+			if found[id] {
+				continue
+			}
+
+			// A broken file still shadows those with the same ID in
+			// directories with lower precedence
+			found[id] = true
+
+			app, err := parser.parse(file)
 			if err != nil {
-				logger.Log("error reading desktop file %s: %v\n", file, err)
+				logger.Log("%v\n", err)
 				continue
 			}
 
-			if !found[app.Name] {
+			if !app.Hidden {
 				apps = append(apps, app)
 			}
-
-			found[app.Name] = true
 		}
 	}
 
 	return apps, nil
 }
 
+// Returns the installed application with the given ID
 func FromID(id string) (*App, error) {
-	apps, err := List()
+	db, err := Load()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, app := range apps {
-		if app.ID == id {
-			return &app, nil
-		}
+	app := db.ByID(id)
+	if app == nil {
+		return nil, errors.New("no application with ID '" + id + "' found")
 	}
 
-	return nil, errors.New("no application with ID '" + id + "' found")
+	return app, nil
 }
 
 func FromFile(desktopFile string) (App, error) {
-	desktopFileEntry, err := ini.Load(desktopFile)
+	desktopFileEntry, err := ini.LoadSources(loadOptions, desktopFile)
 	if err != nil {
 		return App{}, fmt.Errorf("error reading from %s: %w", desktopFile, err)
 	}
 
 	desktopSection := desktopFileEntry.Section("Desktop Entry")
 
-	name := desktopSection.Key("Name").String()
+	id := fileID(desktopFile)
+
+	// A deleted entry needs no other keys
+	if desktopSection.Key("Hidden").MustBool(false) {
+		return App{ID: id, Filename: desktopFile, Hidden: true}, nil
+	}
+
+	name := localeString(desktopSection, "Name")
 	if name == "" {
 		return App{}, fmt.Errorf("error reading from %s: no Name found in [Desktop Entry] section", desktopFile)
 	}
 
 	icon := desktopSection.Key("Icon").String()
-	if icon == "" {
+	hasIcon := icon != ""
+	if !hasIcon {
 		icon = "application-x-executable"
 	}
 
+	// Applications started over D-Bus don't need an Exec line
+	dbusActivatable := desktopSection.Key("DBusActivatable").MustBool(false)
+
 	cmd := desktopSection.Key("Exec").String()
-	if cmd == "" {
+	if cmd == "" && !dbusActivatable {
 		return App{}, fmt.Errorf("error getting command from %s: no Exec line found", desktopFile)
 	}
 
-	// Remove any positional arguments (like %U)
-	fieldCodes := []string{"%f", "%F", "%u", "%U", "%i", "%c", "%k", "%d", "%D", "%n", "%N", "%v", "%m"}
-	for _, code := range fieldCodes {
-		cmd = strings.ReplaceAll(cmd, code, "")
+	if cmd != "" {
+		if _, err := SplitExec(cmd); err != nil {
+			return App{}, fmt.Errorf("error getting command from %s: %w", desktopFile, err)
+		}
 	}
 
-	cmd = strings.TrimSpace(cmd)
-
 	chdir := desktopSection.Key("Path").String()
 
-	basename := strings.TrimSuffix(path.Base(desktopFile), ".desktop")
+	actions := readActions(desktopFileEntry, desktopFile, icon, dbusActivatable)
 
 	return App{
-		Icon:     icon,
-		Name:     name,
-		Filename: desktopFile,
-		ID:       basename,
-		Exec:     cmd,
-		Path:     chdir,
+		Icon:            icon,
+		Name:            name,
+		GenericName:     localeString(desktopSection, "GenericName"),
+		Comment:         localeString(desktopSection, "Comment"),
+		Keywords:        localeList(desktopSection, "Keywords"),
+		Filename:        desktopFile,
+		ID:              id,
+		Exec:            cmd,
+		Path:            chdir,
+		Terminal:        desktopSection.Key("Terminal").MustBool(false),
+		DBusActivatable: dbusActivatable,
+		Actions:         actions,
+		MimeTypes:       readList(desktopSection, "MimeType"),
+		NoDisplay:       desktopSection.Key("NoDisplay").MustBool(false),
+		TryExec:         desktopSection.Key("TryExec").String(),
+		StartupWMClass:  desktopSection.Key("StartupWMClass").String(),
+		FlatpakID:       desktopSection.Key("X-Flatpak").String(),
+		OnlyShowIn:      readList(desktopSection, "OnlyShowIn"),
+		NotShowIn:       readList(desktopSection, "NotShowIn"),
+		hasIcon:         hasIcon,
 	}, nil
 }
 
-func getSearchDirs() ([]string, error) {
+// Read the actions listed in the Actions key of the [Desktop Entry] group.
+// Actions without a group, a Name, or a valid Exec line are skipped.
+//
+// appIcon: The application's icon, used for actions that don't set their own
+//
+// dbusActivatable: Whether the application is started over D-Bus, in which
+// case actions are too, and don't need an Exec line
+func readActions(desktopFileEntry *ini.File, desktopFile string, appIcon string, dbusActivatable bool) []Action {
+	actions := make([]Action, 0)
+
+	for _, id := range readList(desktopFileEntry.Section("Desktop Entry"), "Actions") {
+		section, err := desktopFileEntry.GetSection("Desktop Action " + id)
+		if err != nil {
+			logger.Log("error reading action %s from %s: no [Desktop Action %s] group found\n", id, desktopFile, id)
+			continue
+		}
+
+		name := localeString(section, "Name")
+		if name == "" {
+			logger.Log("error reading action %s from %s: no Name found\n", id, desktopFile)
+			continue
+		}
+
+		cmd := section.Key("Exec").String()
+		if cmd == "" && !dbusActivatable {
+			continue
+		}
+
+		if cmd != "" {
+			if _, err := SplitExec(cmd); err != nil {
+				logger.Log("error reading action %s from %s: %v\n", id, desktopFile, err)
+				continue
+			}
+		}
+
+		icon := section.Key("Icon").String()
+		if icon == "" {
+			icon = appIcon
+		}
+
+		actions = append(actions, Action{
+			ID:   id,
+			Name: name,
+			Icon: icon,
+			Exec: cmd,
+		})
+	}
+
+	return actions
+}
+
+// Read a key whose value is a list of strings separated by semicolons
+func readList(section *ini.Section, key string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(section.Key(key).String(), ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+// Returns the directories searched for .desktop files, highest precedence first
+func SearchDirs() ([]string, error) {
 	xdgDataDirs := getXDGDataDirs()
 	xdgDataHome, err := getXDGDataHome()
 	if err != nil {
@@ -165,19 +381,95 @@ func getXDGDataHome() (string, error) {
 	return xdgDataHome, nil
 }
 
+// Returns the desktop files in dir and its subdirectories
 func getDesktopFiles(dir string) ([]string, error) {
-	dirEntries, err := os.ReadDir(dir)
-	if err != nil {
+	if _, err := os.Stat(dir); err != nil {
 		// TODO: add a mechanism to enable verbose logging, only log here if verbose logging is enabled
 		return nil, fmt.Errorf("error reading %s: %w", dir, err)
 	}
 
 	desktopFiles := make([]string, 0)
-	for _, entry := range dirEntries {
-		if strings.HasSuffix(entry.Name(), ".desktop") {
-			desktopFiles = append(desktopFiles, path.Join(dir, entry.Name()))
+	err := walkDir(dir, func(file string, entry fs.DirEntry) {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".desktop") {
+			desktopFiles = append(desktopFiles, file)
 		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", dir, err)
 	}
 
 	return desktopFiles, nil
 }
+
+// Call f with dir and everything under it. Unlike filepath.WalkDir, dir may be
+// a symlink to a directory, as in Nix profiles (e.g.
+// ~/.nix-profile/share/applications), and paths are still given under dir
+// rather than under the directory it links to. Symlinks below dir aren't
+// followed into.
+func walkDir(dir string, f func(file string, entry fs.DirEntry)) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			logger.Log("%v\n", err)
+			return nil
+		}
+
+		if rel, err := filepath.Rel(root, file); err == nil {
+			file = filepath.Join(dir, rel)
+		}
+
+		f(file, entry)
+		return nil
+	})
+}
+
+// Returns the application directories and all of their subdirectories, for
+// watching for changes to desktop files
+func ApplicationDirs() ([]string, error) {
+	searchDirs, err := SearchDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0, len(searchDirs))
+	for _, dir := range searchDirs {
+		walkDir(dir, func(file string, entry fs.DirEntry) {
+			if entry.IsDir() {
+				dirs = append(dirs, file)
+			}
+		})
+	}
+
+	return dirs, nil
+}
+
+// Returns the ID of a desktop file found in an application directory, without
+// the .desktop suffix: its path relative to the directory, with slashes
+// replaced by dashes, e.g. applications/kde/foo.desktop is kde-foo
+func desktopFileID(dir string, file string) string {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = path.Base(file)
+	}
+
+	return strings.TrimSuffix(strings.ReplaceAll(rel, "/", "-"), ".desktop")
+}
+
+// Returns the ID of a desktop file, relative to the application directory that
+// contains it, or from its name alone if it's in none of them
+func fileID(file string) string {
+	dirs, err := SearchDirs()
+	if err == nil {
+		for _, dir := range dirs {
+			if strings.HasPrefix(file, dir+"/") {
+				return desktopFileID(dir, file)
+			}
+		}
+	}
+
+	return desktopFileID(path.Dir(file), file)
+}
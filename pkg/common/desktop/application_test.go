@@ -0,0 +1,114 @@
+package desktop
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeDesktopFile(t *testing.T, file string, name string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	contents := "[Desktop Entry]\nType=Application\nName=" + name + "\nExec=true\n"
+	if err := os.WriteFile(file, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Nix profiles make the applications directory a symlink into the store
+func TestSymlinkedApplicationDir(t *testing.T) {
+	tmp := t.TempDir()
+
+	target := filepath.Join(tmp, "store", "share", "applications")
+	writeDesktopFile(t, filepath.Join(target, "foo.desktop"), "Foo")
+	writeDesktopFile(t, filepath.Join(target, "kde", "bar.desktop"), "Bar")
+
+	dataHome := filepath.Join(tmp, "profile", "share")
+	if err := os.MkdirAll(dataHome, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(dataHome, "applications")
+	if err := os.Symlink(target, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("HOME", tmp)
+	t.Setenv("XDG_DATA_HOME", dataHome)
+	t.Setenv("XDG_DATA_DIRS", filepath.Join(tmp, "missing"))
+
+	apps, err := scan(newFileParser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	for _, app := range apps {
+		got[app.ID] = app.Filename
+	}
+
+	want := map[string]string{
+		"foo":     filepath.Join(dir, "foo.desktop"),
+		"kde-bar": filepath.Join(dir, "kde", "bar.desktop"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("scan found %v, want %v", got, want)
+	}
+	for id, file := range want {
+		if got[id] != file {
+			t.Errorf("app %s has filename %q, want %q", id, got[id], file)
+		}
+	}
+
+	dirs, err := ApplicationDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{dir, filepath.Join(dir, "kde")} {
+		if !slices.Contains(dirs, want) {
+			t.Errorf("ApplicationDirs() = %v, missing %s", dirs, want)
+		}
+	}
+}
+
+// A broken desktop file in the user's directory hides the system one with the
+// same ID, as a working one would
+func TestBrokenFileShadows(t *testing.T) {
+	tmp := t.TempDir()
+	dataHome := filepath.Join(tmp, "home", ".local", "share")
+	dataDir := filepath.Join(tmp, "usr", "share")
+
+	writeDesktopFile(t, filepath.Join(dataDir, "applications", "foo.desktop"), "Foo")
+	writeDesktopFile(t, filepath.Join(dataDir, "applications", "bar.desktop"), "Bar")
+
+	// No Name
+	broken := filepath.Join(dataHome, "applications", "foo.desktop")
+	if err := os.MkdirAll(filepath.Dir(broken), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(broken, []byte("[Desktop Entry]\nType=Application\nExec=true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("HOME", filepath.Join(tmp, "home"))
+	t.Setenv("XDG_DATA_HOME", dataHome)
+	t.Setenv("XDG_DATA_DIRS", dataDir)
+
+	apps, err := scan(newFileParser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, 0, len(apps))
+	for _, app := range apps {
+		ids = append(ids, app.ID)
+	}
+	if !slices.Equal(ids, []string{"bar"}) {
+		t.Errorf("scan found %v, want [bar]", ids)
+	}
+}
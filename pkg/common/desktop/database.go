@@ -0,0 +1,371 @@
+package desktop
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/common/state"
+	"github.com/jplein/launchit/pkg/common/state/locations"
+)
+
+// Bump when App changes, so that caches written by older versions are ignored
+const databaseVersion = 4
+
+// The installed applications, indexed for lookups by ID, WM class and name,
+// and for matching windows to applications. Keys of the indexes other than
+// byID are lowercase.
+type Database struct {
+	apps      []App
+	byID      map[string]int
+	byWMClass map[string][]int
+	byName    map[string][]int
+
+	byLowerID   map[string][]int
+	byShortID   map[string][]int
+	byFlatpakID map[string][]int
+	byExecName  map[string][]int
+}
+
+func newDatabase(apps []App) *Database {
+	db := &Database{
+		apps:        apps,
+		byID:        make(map[string]int, len(apps)),
+		byWMClass:   make(map[string][]int),
+		byName:      make(map[string][]int),
+		byLowerID:   make(map[string][]int),
+		byShortID:   make(map[string][]int),
+		byFlatpakID: make(map[string][]int),
+		byExecName:  make(map[string][]int),
+	}
+
+	add := func(index map[string][]int, key string, i int) {
+		if key != "" {
+			key = strings.ToLower(key)
+			index[key] = append(index[key], i)
+		}
+	}
+
+	for i, app := range apps {
+		db.byID[app.ID] = i
+
+		add(db.byWMClass, app.StartupWMClass, i)
+		add(db.byName, app.Name, i)
+		add(db.byLowerID, app.ID, i)
+		add(db.byFlatpakID, app.FlatpakID, i)
+		add(db.byExecName, execName(app.Exec), i)
+
+		// The last part of a reverse-DNS ID, e.g. nautilus for
+		// org.gnome.Nautilus
+		if dot := strings.LastIndex(app.ID, "."); dot >= 0 {
+			add(db.byShortID, app.ID[dot+1:], i)
+		}
+	}
+
+	return db
+}
+
+// Returns the name of the program an Exec line runs, without its directory,
+// e.g. code for /usr/bin/code --new-window. Programs started through env
+// are looked through. Returns "" for Flatpak, whose applications are matched
+// by their Flatpak ID instead.
+func execName(exec string) string {
+	args, err := SplitExec(exec)
+	if err != nil {
+		return ""
+	}
+
+	if path.Base(args[0]) == "env" {
+		args = args[1:]
+		for len(args) > 0 && (strings.HasPrefix(args[0], "-") || strings.Contains(args[0], "=")) {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return ""
+		}
+	}
+
+	name := path.Base(args[0])
+	if name == "flatpak" || strings.HasPrefix(name, "%") {
+		return ""
+	}
+
+	return name
+}
+
+// Returns all applications, in the order of List
+func (db *Database) Apps() []App {
+	apps := make([]App, len(db.apps))
+	copy(apps, db.apps)
+	return apps
+}
+
+// Returns the application with the given desktop file ID (without .desktop),
+// or nil if there is none
+func (db *Database) ByID(id string) *App {
+	i, ok := db.byID[id]
+	if !ok {
+		return nil
+	}
+
+	app := db.apps[i]
+	return &app
+}
+
+// Returns the applications whose StartupWMClass matches class, ignoring case
+func (db *Database) ByWMClass(class string) []App {
+	return db.lookup(db.byWMClass, strings.ToLower(class))
+}
+
+// Returns the applications with the given name, ignoring case
+func (db *Database) ByName(name string) []App {
+	return db.lookup(db.byName, strings.ToLower(name))
+}
+
+// Returns the application that a window belongs to, or nil if none matches
+//
+// windowAppID: The window's app ID (Wayland) or WM class (X11). This is usually
+// the application's desktop file ID, but not always, so the application is
+// looked for by, in order: desktop file ID, StartupWMClass, Flatpak ID,
+// desktop file ID ignoring case, the last part of a reverse-DNS desktop file
+// ID (e.g. nautilus for org.gnome.Nautilus), the name of the program in Exec,
+// and finally the application's name. Other than the first, all ignore case.
+func (db *Database) ForWindow(windowAppID string) *App {
+	if windowAppID == "" {
+		return nil
+	}
+
+	if app := db.ByID(windowAppID); app != nil {
+		return app
+	}
+
+	key := strings.ToLower(windowAppID)
+	for _, index := range []map[string][]int{
+		db.byWMClass,
+		db.byFlatpakID,
+		db.byLowerID,
+		db.byShortID,
+		db.byExecName,
+		db.byName,
+	} {
+		if matches := index[key]; len(matches) > 0 {
+			app := db.apps[matches[0]]
+			return &app
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) lookup(index map[string][]int, key string) []App {
+	apps := make([]App, 0, len(index[key]))
+	for _, i := range index[key] {
+		apps = append(apps, db.apps[i])
+	}
+
+	return apps
+}
+
+var (
+	loaded            *Database
+	loadedFingerprint string
+
+	// Set by Invalidate, to rescan even though the fingerprint is unchanged
+	stale bool
+
+	// The desktop files read by the last scan, by path
+	parsedFiles = make(map[string]parsedFile)
+
+	loadMutex sync.Mutex
+)
+
+// A desktop file as parsed by a scan
+type parsedFile struct {
+	modTime time.Time
+	size    int64
+	app     App
+	err     error
+}
+
+// Parses desktop files during a scan, reusing the results of the previous scan
+// for files that haven't changed since, so that a change to one file doesn't
+// cause every file to be parsed again
+type fileParser struct {
+	previous map[string]parsedFile
+	current  map[string]parsedFile
+}
+
+func newFileParser() *fileParser {
+	return &fileParser{previous: parsedFiles, current: make(map[string]parsedFile)}
+}
+
+func (p *fileParser) parse(file string) (App, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return App{}, fmt.Errorf("error reading desktop file %s: %w", file, err)
+	}
+
+	prev, ok := p.previous[file]
+	if ok && prev.modTime.Equal(info.ModTime()) && prev.size == info.Size() {
+		p.current[file] = prev
+		return prev.app, prev.err
+	}
+
+	app, err := FromFile(file)
+
+	p.current[file] = parsedFile{modTime: info.ModTime(), size: info.Size(), app: app, err: err}
+	return app, err
+}
+
+// Mark the database as out of date, e.g. because a desktop file was edited in
+// place, which doesn't change the directory's modification time. The next
+// Load scans the application directories again, parsing only the files that
+// changed.
+func Invalidate() {
+	loadMutex.Lock()
+	defer loadMutex.Unlock()
+
+	stale = true
+}
+
+// Returns the desktop database. It's rebuilt only when the application
+// directories change (or the locale, which changes names), or after
+// Invalidate: otherwise it's reused from an earlier call, or read from the
+// cache on disk.
+func Load() (*Database, error) {
+	loadMutex.Lock()
+	defer loadMutex.Unlock()
+
+	fingerprint, err := Fingerprint()
+	if err != nil {
+		return nil, fmt.Errorf("error loading desktop database: %w", err)
+	}
+
+	if !stale {
+		if loaded != nil && loadedFingerprint == fingerprint {
+			return loaded, nil
+		}
+
+		if apps, ok := readDatabaseCache(fingerprint); ok {
+			loaded, loadedFingerprint = newDatabase(apps), fingerprint
+			return loaded, nil
+		}
+	}
+
+	parser := newFileParser()
+	apps, err := scan(parser)
+	if err != nil {
+		return nil, fmt.Errorf("error loading desktop database: %w", err)
+	}
+	parsedFiles = parser.current
+	stale = false
+
+	if err := writeDatabaseCache(fingerprint, apps); err != nil {
+		logger.Log("%v\n", err)
+	}
+
+	loaded, loadedFingerprint = newDatabase(apps), fingerprint
+	return loaded, nil
+}
+
+// Summarize the modification times of the application directories, the
+// directories they are symlinks to, and the locale, so that changes to the
+// installed applications can be detected without scanning them
+func Fingerprint() (string, error) {
+	dirs, err := ApplicationDirs()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "v%d;%s;", databaseVersion, messagesLocale())
+
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			continue
+		}
+
+		// A symlinked directory (e.g. in a Nix profile) may be switched to
+		// another with the same modification time
+		target, _ := filepath.EvalSymlinks(dir)
+
+		fmt.Fprintf(&sb, "%s>%s:%d;", dir, target, info.ModTime().UnixNano())
+	}
+
+	return sb.String(), nil
+}
+
+// The desktop database as saved to disk
+type databaseCache struct {
+	Fingerprint string      `json:"fingerprint"`
+	Apps        []cachedApp `json:"apps"`
+}
+
+// An App, along with its unexported fields
+type cachedApp struct {
+	App
+	HasIcon bool `json:"has_icon"`
+}
+
+// Read the applications from the cache, if it was written with the same
+// fingerprint
+func readDatabaseCache(fingerprint string) ([]App, bool) {
+	file, err := locations.DesktopCacheFilename()
+	if err != nil {
+		return nil, false
+	}
+
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, false
+	}
+
+	cache := databaseCache{}
+	if err := json.Unmarshal(buf, &cache); err != nil {
+		logger.Log("error reading desktop cache: error parsing %s as JSON: %v\n", file, err)
+		return nil, false
+	}
+
+	if cache.Fingerprint != fingerprint {
+		return nil, false
+	}
+
+	apps := make([]App, 0, len(cache.Apps))
+	for _, c := range cache.Apps {
+		app := c.App
+		app.hasIcon = c.HasIcon
+		apps = append(apps, app)
+	}
+
+	return apps, true
+}
+
+func writeDatabaseCache(fingerprint string, apps []App) error {
+	file, err := locations.DesktopCacheFilename()
+	if err != nil {
+		return fmt.Errorf("error saving desktop cache: %w", err)
+	}
+
+	cache := databaseCache{Fingerprint: fingerprint, Apps: make([]cachedApp, 0, len(apps))}
+	for _, app := range apps {
+		cache.Apps = append(cache.Apps, cachedApp{App: app, HasIcon: app.hasIcon})
+	}
+
+	buf, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("error saving desktop cache: error marshaling to JSON: %w", err)
+	}
+
+	if err := state.WriteFileAtomic(file, buf, 0o644); err != nil {
+		return fmt.Errorf("error saving desktop cache: %w", err)
+	}
+
+	return nil
+}
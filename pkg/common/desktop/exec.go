@@ -0,0 +1,268 @@
+package desktop
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Split an Exec line into arguments, following the quoting rules of the
+// Desktop Entry Specification. Field codes are left in place.
+//
+// Values in desktop files first have the general string escapes (\s, \n, \t,
+// \r and \\) applied. Arguments are then separated by spaces, and may be
+// enclosed in double quotes, inside which ", `, $ and \ are escaped with a
+// backslash.
+func SplitExec(exec string) ([]string, error) {
+	runes := []rune(unescapeString(exec))
+
+	args := make([]string, 0)
+	var arg strings.Builder
+	inArg := false
+	inQuotes := false
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		if inQuotes {
+			switch r {
+			case '"':
+				inQuotes = false
+			case '\\':
+				if i+1 < len(runes) && strings.ContainsRune("\"`$\\", runes[i+1]) {
+					i++
+					arg.WriteRune(runes[i])
+				} else {
+					arg.WriteRune(r)
+				}
+			default:
+				arg.WriteRune(r)
+			}
+			continue
+		}
+
+		switch r {
+		case ' ', '\t', '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case '"':
+			inQuotes = true
+			inArg = true
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if inQuotes {
+		return nil, fmt.Errorf("error parsing Exec line '%s': unterminated quote", exec)
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("error parsing Exec line '%s': no program given", exec)
+	}
+
+	return args, nil
+}
+
+// Apply the escapes allowed in values of type string
+func unescapeString(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 's':
+			b.WriteByte(' ')
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '\\':
+			b.WriteByte('\\')
+		default:
+			// Leave other escapes for the quoting rules
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
+
+// Returns the arguments to run the application with
+//
+// uris: Files and URLs to open. Local files may be given as paths or file://
+// URLs.
+func (a App) Argv(uris []string) ([]string, error) {
+	return a.expand(a.Exec, uris)
+}
+
+// Returns the arguments to run one of the application's actions with
+//
+// uris: Files and URLs to open, as for Argv
+func (a App) ActionArgv(action Action, uris []string) ([]string, error) {
+	return a.expand(action.Exec, uris)
+}
+
+// Split an Exec line and expand its field codes. An argument that consists
+// only of %F, %U or %i may expand to any number of arguments, including none.
+// %f and %u expand to the first file or URL only. An argument that is only a
+// deprecated field code is dropped.
+func (a App) expand(exec string, uris []string) ([]string, error) {
+	args, err := SplitExec(exec)
+	if err != nil {
+		return nil, err
+	}
+
+	files := localFiles(uris)
+
+	argv := make([]string, 0, len(args))
+	for i, arg := range args {
+		switch arg {
+		case "%F":
+			argv = append(argv, files...)
+			continue
+		case "%U":
+			argv = append(argv, uris...)
+			continue
+		case "%i":
+			if a.hasIcon {
+				argv = append(argv, "--icon", a.Icon)
+			}
+			continue
+		case "%f", "%u":
+			list := files
+			if arg == "%u" {
+				list = uris
+			}
+
+			if len(list) > 0 {
+				argv = append(argv, list[0])
+			}
+			continue
+		case "%d", "%D", "%n", "%N", "%v", "%m":
+			// Deprecated, and expand to no argument at all
+			continue
+		}
+
+		expanded := a.expandArg(arg, files, uris)
+
+		// The program itself can't be a field code, but may contain %%
+		if i == 0 && expanded == "" {
+			return nil, fmt.Errorf("error parsing Exec line '%s': no program given", exec)
+		}
+
+		argv = append(argv, expanded)
+	}
+
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("error parsing Exec line '%s': no program given", exec)
+	}
+
+	return argv, nil
+}
+
+// Expand the field codes embedded in a single argument. The spec doesn't allow
+// %F, %U or %i here, or unknown field codes, but rather than refuse to start
+// the application, the first two are treated like %f and %u, and the others
+// are kept as they are.
+func (a App) expandArg(arg string, files []string, uris []string) string {
+	if !strings.Contains(arg, "%") {
+		return arg
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(arg); i++ {
+		if arg[i] != '%' || i+1 == len(arg) {
+			b.WriteByte(arg[i])
+			continue
+		}
+
+		i++
+		switch arg[i] {
+		case '%':
+			b.WriteByte('%')
+		case 'c':
+			b.WriteString(a.Name)
+		case 'k':
+			b.WriteString(a.Filename)
+		case 'f', 'F':
+			if len(files) > 0 {
+				b.WriteString(files[0])
+			}
+		case 'u', 'U':
+			if len(uris) > 0 {
+				b.WriteString(uris[0])
+			}
+		case 'd', 'D', 'n', 'N', 'v', 'm':
+			// Deprecated, and expand to nothing
+		default:
+			b.WriteByte('%')
+			b.WriteByte(arg[i])
+		}
+	}
+
+	return b.String()
+}
+
+// Returns the local paths of the files among uris. file:// URLs are converted to
+// paths, and other URLs are dropped, since applications that take %f or %F
+// can only open local files.
+func localFiles(uris []string) []string {
+	files := make([]string, 0, len(uris))
+	for _, uri := range uris {
+		if !strings.Contains(uri, "://") {
+			files = append(files, uri)
+			continue
+		}
+
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme != "file" {
+			continue
+		}
+
+		files = append(files, u.Path)
+	}
+
+	return files
+}
+
+// Split files and URLs into groups to open with one instance of the
+// application each. Applications whose Exec line takes a list (%F or %U) get a
+// single group; others get one group per file or URL. There is always at least
+// one group, so that the application is started even with nothing to open.
+func (a App) Batches(uris []string) [][]string {
+	if len(uris) == 0 {
+		return [][]string{nil}
+	}
+
+	args, err := SplitExec(a.Exec)
+	if err == nil {
+		for _, arg := range args {
+			if arg == "%F" || arg == "%U" {
+				return [][]string{uris}
+			}
+		}
+	}
+
+	batches := make([][]string, 0, len(uris))
+	for _, uri := range uris {
+		batches = append(batches, []string{uri})
+	}
+
+	return batches
+}
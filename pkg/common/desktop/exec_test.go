@@ -0,0 +1,98 @@
+package desktop
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitExec(t *testing.T) {
+	tests := []struct {
+		exec string
+		want []string
+	}{
+		{exec: "editor --new-window", want: []string{"editor", "--new-window"}},
+		{exec: "editor  \t--new-window ", want: []string{"editor", "--new-window"}},
+		{exec: `"/opt/My Editor/editor" %F`, want: []string{"/opt/My Editor/editor", "%F"}},
+		{exec: "sh -c \"echo \\\"hi\\\" \\$HOME \\`date\\`\"", want: []string{"sh", "-c", "echo \"hi\" $HOME `date`"}},
+
+		// The general string escapes come first, so a backslash inside quotes is
+		// written as four in the file
+		{exec: `editor "C:\\\\Users"`, want: []string{"editor", `C:\Users`}},
+		{exec: `editor --dir="a b"c`, want: []string{"editor", "--dir=a bc"}},
+		{exec: `editor 100%%`, want: []string{"editor", "100%%"}},
+		{exec: `editor ""`, want: []string{"editor", ""}},
+	}
+
+	for _, test := range tests {
+		got, err := SplitExec(test.exec)
+		if err != nil {
+			t.Errorf("SplitExec(%s) returned error: %v", test.exec, err)
+		} else if !slices.Equal(got, test.want) {
+			t.Errorf("SplitExec(%s) = %q, want %q", test.exec, got, test.want)
+		}
+	}
+
+	for _, exec := range []string{`editor "unterminated`, "", "   "} {
+		if got, err := SplitExec(exec); err == nil {
+			t.Errorf("SplitExec(%q) = %q, want an error", exec, got)
+		}
+	}
+}
+
+func TestArgv(t *testing.T) {
+	app := App{
+		Name:     "Editor",
+		Filename: "/usr/share/applications/editor.desktop",
+		Icon:     "editor",
+		hasIcon:  true,
+	}
+	noIcon := App{Name: "Editor", Filename: "/usr/share/applications/editor.desktop"}
+
+	uris := []string{"/tmp/a.txt", "https://example.com/", "file:///tmp/b%20c.txt"}
+
+	tests := []struct {
+		app  App
+		exec string
+		uris []string
+		want []string
+	}{
+		{app: app, exec: "editor %U", uris: uris, want: []string{"editor", "/tmp/a.txt", "https://example.com/", "file:///tmp/b%20c.txt"}},
+		{app: app, exec: "editor %F", uris: uris, want: []string{"editor", "/tmp/a.txt", "/tmp/b c.txt"}},
+		{app: app, exec: "editor %u", uris: uris, want: []string{"editor", "/tmp/a.txt"}},
+		{app: app, exec: "editor %f", uris: []string{"https://example.com/", "/tmp/a.txt"}, want: []string{"editor", "/tmp/a.txt"}},
+		{app: app, exec: "editor %f %U", want: []string{"editor"}},
+		{app: app, exec: "editor 100%%", want: []string{"editor", "100%"}},
+		{app: app, exec: "editor %i --name %c %k", want: []string{"editor", "--icon", "editor", "--name", "Editor", "/usr/share/applications/editor.desktop"}},
+		{app: noIcon, exec: "editor %i", want: []string{"editor"}},
+
+		// Field codes embedded in a word expand in place
+		{app: app, exec: "editor --file=%f --title=%c", uris: uris, want: []string{"editor", "--file=/tmp/a.txt", "--title=Editor"}},
+		{app: app, exec: "editor --files=%F", uris: uris, want: []string{"editor", "--files=/tmp/a.txt"}},
+		{app: app, exec: "editor --unknown=%z", want: []string{"editor", "--unknown=%z"}},
+
+		// Deprecated field codes expand to nothing, and on their own to no
+		// argument
+		{app: app, exec: "editor %d %D %n %N %v %m --flag", want: []string{"editor", "--flag"}},
+		{app: app, exec: "editor --dir=%d", want: []string{"editor", "--dir="}},
+	}
+
+	for _, test := range tests {
+		a := test.app
+		a.Exec = test.exec
+
+		got, err := a.Argv(test.uris)
+		if err != nil {
+			t.Errorf("Argv(%q) for %s returned error: %v", test.uris, test.exec, err)
+		} else if !slices.Equal(got, test.want) {
+			t.Errorf("Argv(%q) for %s = %q, want %q", test.uris, test.exec, got, test.want)
+		}
+	}
+
+	for _, exec := range []string{"%f", "%d", `editor "unterminated`} {
+		a := app
+		a.Exec = exec
+		if got, err := a.Argv(nil); err == nil {
+			t.Errorf("Argv for %s = %q, want an error", exec, got)
+		}
+	}
+}
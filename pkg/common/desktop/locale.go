@@ -0,0 +1,73 @@
+package desktop
+
+import (
+	"os"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// Returns the locale that messages should be shown in, from $LC_ALL,
+// $LC_MESSAGES or $LANG, e.g. de_DE.UTF-8
+func messagesLocale() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// Returns the suffixes to try for localized keys, in the order given by the
+// Desktop Entry Specification, e.g. for sr_YU.UTF-8@Latn: sr_YU@Latn, sr_YU,
+// sr@Latn, sr. The encoding is ignored. Returns nothing for the C and POSIX
+// locales.
+func localeSuffixes(locale string) []string {
+	if locale == "" || locale == "C" || locale == "POSIX" {
+		return nil
+	}
+
+	locale, modifier, hasModifier := strings.Cut(locale, "@")
+	locale, _, _ = strings.Cut(locale, ".")
+	lang, country, hasCountry := strings.Cut(locale, "_")
+
+	suffixes := make([]string, 0, 4)
+	if hasCountry && hasModifier {
+		suffixes = append(suffixes, lang+"_"+country+"@"+modifier)
+	}
+	if hasCountry {
+		suffixes = append(suffixes, lang+"_"+country)
+	}
+	if hasModifier {
+		suffixes = append(suffixes, lang+"@"+modifier)
+	}
+
+	return append(suffixes, lang)
+}
+
+// Returns the value of a localestring key for the current locale, e.g.
+// Name[de] for de_DE.UTF-8, or the unlocalized value if there's no
+// translation
+func localeString(section *ini.Section, key string) string {
+	for _, suffix := range localeSuffixes(messagesLocale()) {
+		if section.HasKey(key + "[" + suffix + "]") {
+			return section.Key(key + "[" + suffix + "]").String()
+		}
+	}
+
+	return section.Key(key).String()
+}
+
+// Returns the value of a localized list key (like Keywords) for the current
+// locale
+func localeList(section *ini.Section, key string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(localeString(section, key), ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
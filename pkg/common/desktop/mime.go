@@ -0,0 +1,461 @@
+package desktop
+
+import (
+	"bufio"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	defaultXDGConfigDirs = "/etc/xdg"
+	defaultXDGConfigHome = "~/.config"
+
+	mimeTypeDirectory = "inode/directory"
+	mimeTypeUnknown   = "application/octet-stream"
+	mimeTypeText      = "text/plain"
+)
+
+// Returns the MIME type of a file or URL. URLs other than file:// URLs get an
+// x-scheme-handler type (e.g. x-scheme-handler/https), as used by mimeapps.list
+// and MimeType keys to name the handler for a scheme.
+func MimeType(uri string) string {
+	if strings.Contains(uri, "://") {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return mimeTypeUnknown
+		}
+
+		if u.Scheme != "file" {
+			return "x-scheme-handler/" + strings.ToLower(u.Scheme)
+		}
+
+		uri = u.Path
+	}
+
+	info, err := os.Stat(uri)
+	if err == nil && info.IsDir() {
+		return mimeTypeDirectory
+	}
+
+	if t := mimeTypeFromGlobs(path.Base(uri)); t != "" {
+		return t
+	}
+
+	if t := mime.TypeByExtension(path.Ext(uri)); t != "" {
+		return canonicalMimeType(t)
+	}
+
+	return sniffMimeType(uri)
+}
+
+// Guess a MIME type from the first bytes of the file
+func sniffMimeType(file string) string {
+	fh, err := os.Open(file)
+	if err != nil {
+		return mimeTypeUnknown
+	}
+	defer fh.Close()
+
+	buf := make([]byte, 512)
+	n, _ := fh.Read(buf)
+	if n == 0 {
+		return mimeTypeText
+	}
+
+	return canonicalMimeType(http.DetectContentType(buf[:n]))
+}
+
+// Strip parameters (like charset) from a MIME type, and resolve aliases
+func canonicalMimeType(t string) string {
+	t, _, _ = strings.Cut(t, ";")
+	t = strings.ToLower(strings.TrimSpace(t))
+
+	loadMimeDatabase()
+	if canonical, ok := aliases[t]; ok {
+		return canonical
+	}
+
+	return t
+}
+
+// Returns the MIME types that t is a kind of, starting with t itself, e.g.
+// text/x-csrc, text/plain
+func mimeTypeAndParents(t string) []string {
+	types := []string{t}
+	seen := map[string]bool{t: true}
+	loadMimeDatabase()
+
+	for i := 0; i < len(types); i++ {
+		parents := subclasses[types[i]]
+		if strings.HasPrefix(types[i], "text/") {
+			parents = append(parents, mimeTypeText)
+		}
+
+		for _, p := range parents {
+			if !seen[p] {
+				seen[p] = true
+				types = append(types, p)
+			}
+		}
+	}
+
+	return types
+}
+
+// Returns the mime directories of the shared MIME-info database, highest
+// precedence first
+func mimeDirs() []string {
+	dirs := make([]string, 0)
+
+	if dataHome, err := getXDGDataHome(); err == nil {
+		dirs = append(dirs, path.Join(dataHome, "mime"))
+	}
+
+	for _, dir := range strings.Split(getXDGDataDirs(), ":") {
+		dirs = append(dirs, path.Join(dir, "mime"))
+	}
+
+	return dirs
+}
+
+// Calls f with the fields of each line in the named file in every mime
+// directory, skipping comments
+func readMimeFiles(name string, separator string, f func(fields []string)) {
+	for _, dir := range mimeDirs() {
+		fh, err := os.Open(path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+
+		scanner := bufio.NewScanner(fh)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			f(strings.Split(line, separator))
+		}
+
+		fh.Close()
+	}
+}
+
+// Returns the MIME type with the best glob match for the filename, or "" if
+// there is none. The highest weight wins, then the longest pattern. Patterns
+// that match with the same case are preferred, so that *.c beats *.C for a.c.
+func mimeTypeFromGlobs(filename string) string {
+	if t := matchGlobs(filename, false); t != "" {
+		return t
+	}
+
+	return matchGlobs(filename, true)
+}
+
+// ignoreCase: Whether to match patterns that aren't marked case-sensitive
+// without regard to case
+func matchGlobs(filename string, ignoreCase bool) string {
+	best := ""
+	bestWeight := -1
+	bestLength := 0
+
+	loadMimeDatabase()
+	for _, g := range globs {
+		pattern := g.Pattern
+		name := filename
+		if ignoreCase && !g.CaseSensitive {
+			pattern = strings.ToLower(pattern)
+			name = strings.ToLower(name)
+		}
+
+		if matched, _ := filepath.Match(pattern, name); !matched {
+			continue
+		}
+
+		if g.Weight > bestWeight || (g.Weight == bestWeight && len(pattern) > bestLength) {
+			best = g.MimeType
+			bestWeight = g.Weight
+			bestLength = len(pattern)
+		}
+	}
+
+	return best
+}
+
+// A filename pattern from a globs2 file
+type glob struct {
+	Weight        int
+	MimeType      string
+	Pattern       string
+	CaseSensitive bool
+}
+
+var (
+	// Canonical MIME types by alias, from the shared MIME-info database
+	aliases map[string]string
+
+	// Parent MIME types by type, from the shared MIME-info database
+	subclasses map[string][]string
+
+	// Filename patterns, from the shared MIME-info database
+	globs []glob
+
+	mimeDatabaseOnce sync.Once
+)
+
+// Read the aliases, subclasses and globs from the shared MIME-info database, on
+// the first call only
+func loadMimeDatabase() {
+	mimeDatabaseOnce.Do(func() {
+		aliases = make(map[string]string)
+		readMimeFiles("aliases", " ", func(fields []string) {
+			if len(fields) == 2 {
+				aliases[fields[0]] = fields[1]
+			}
+		})
+
+		subclasses = make(map[string][]string)
+		readMimeFiles("subclasses", " ", func(fields []string) {
+			if len(fields) == 2 {
+				subclasses[fields[0]] = append(subclasses[fields[0]], fields[1])
+			}
+		})
+
+		globs = make([]glob, 0)
+		readMimeFiles("globs2", ":", func(fields []string) {
+			if len(fields) < 3 {
+				return
+			}
+
+			weight, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return
+			}
+
+			globs = append(globs, glob{
+				Weight:        weight,
+				MimeType:      fields[1],
+				Pattern:       fields[2],
+				CaseSensitive: len(fields) > 3 && strings.Contains(fields[3], "cs"),
+			})
+		})
+	})
+}
+
+// Associations between MIME types and applications from the mimeapps.list
+// files. Applications are identified by desktop file ID, e.g. firefox.desktop.
+type MimeApps struct {
+	// Preferred applications for each type, most preferred first
+	Defaults map[string][]string
+
+	// Applications that handle each type, in addition to those that list it in
+	// their MimeType key
+	Added map[string][]string
+
+	// Applications that don't handle each type, even if they list it in their
+	// MimeType key. Defaults and added associations from files with lower
+	// precedence than the one removing them are already left out of Defaults
+	// and Added.
+	Removed map[string][]string
+}
+
+// Read the mimeapps.list files, in the order of precedence given by the
+// Association between MIME types and applications specification. Files with
+// higher precedence are read first, and a type's entries from earlier files
+// come before those from later ones. A file's removed associations only apply
+// to the files after it.
+func ReadMimeApps() *MimeApps {
+	m := &MimeApps{
+		Defaults: make(map[string][]string),
+		Added:    make(map[string][]string),
+		Removed:  make(map[string][]string),
+	}
+
+	for _, file := range mimeAppsFiles() {
+		f, err := ini.LoadSources(loadOptions, file)
+		if err != nil {
+			continue
+		}
+
+		m.merge(m.Defaults, readMimeAppsSection(f, "Default Applications"))
+		m.merge(m.Added, readMimeAppsSection(f, "Added Associations"))
+
+		for t, ids := range readMimeAppsSection(f, "Removed Associations") {
+			m.Removed[t] = append(m.Removed[t], ids...)
+		}
+	}
+
+	return m
+}
+
+// Add the associations from a file to those from the files before it, leaving
+// out those that the files before it removed
+func (m *MimeApps) merge(associations map[string][]string, file map[string][]string) {
+	for t, ids := range file {
+		for _, id := range ids {
+			if !slices.Contains(m.Removed[t], id) {
+				associations[t] = append(associations[t], id)
+			}
+		}
+	}
+}
+
+// Returns the desktop file IDs in a section of a mimeapps.list file, by MIME
+// type
+func readMimeAppsSection(f *ini.File, name string) map[string][]string {
+	associations := make(map[string][]string)
+
+	section, err := f.GetSection(name)
+	if err != nil {
+		return associations
+	}
+
+	for _, key := range section.Keys() {
+		t := canonicalMimeType(key.Name())
+		for _, id := range strings.Split(key.String(), ";") {
+			id = strings.TrimSpace(id)
+			if id != "" {
+				associations[t] = append(associations[t], id)
+			}
+		}
+	}
+
+	return associations
+}
+
+// Returns the mimeapps.list files that may exist, highest precedence first
+func mimeAppsFiles() []string {
+	desktops := currentDesktops()
+	for i, d := range desktops {
+		desktops[i] = strings.ToLower(d)
+	}
+
+	// Desktop-specific files come before the generic one in each directory
+	inDir := func(dir string) []string {
+		files := make([]string, 0, len(desktops)+1)
+		for _, d := range desktops {
+			files = append(files, path.Join(dir, d+"-mimeapps.list"))
+		}
+		return append(files, path.Join(dir, "mimeapps.list"))
+	}
+
+	files := make([]string, 0)
+
+	if configHome, err := getXDGConfigHome(); err == nil {
+		files = append(files, inDir(configHome)...)
+	}
+
+	for _, dir := range strings.Split(getXDGConfigDirs(), ":") {
+		files = append(files, inDir(dir)...)
+	}
+
+	if dataHome, err := getXDGDataHome(); err == nil {
+		files = append(files, inDir(path.Join(dataHome, "applications"))...)
+	}
+
+	for _, dir := range strings.Split(getXDGDataDirs(), ":") {
+		files = append(files, inDir(path.Join(dir, "applications"))...)
+	}
+
+	return files
+}
+
+// Returns the applications that can open files of the given MIME type, the
+// default application first. Applications that open a parent type (e.g.,
+// text/plain for text/x-csrc) are included, after those that open the type
+// itself.
+//
+// apps: All installed applications, as returned by List. Those that can't
+// open files in this session (see App.CanOpenFiles) are skipped.
+func (m *MimeApps) Handlers(apps []App, mimeType string) []App {
+	usable := make([]App, 0, len(apps))
+	byDesktopID := make(map[string]App, len(apps))
+	for _, app := range apps {
+		if app.CanOpenFiles() {
+			usable = append(usable, app)
+			byDesktopID[app.DesktopFileID()] = app
+		}
+	}
+	apps = usable
+
+	handlers := make([]App, 0)
+	added := make(map[string]bool)
+	add := func(app App) {
+		if !added[app.Filename] {
+			added[app.Filename] = true
+			handlers = append(handlers, app)
+		}
+	}
+
+	for _, t := range mimeTypeAndParents(canonicalMimeType(mimeType)) {
+		removed := make(map[string]bool)
+		for _, id := range m.Removed[t] {
+			removed[id] = true
+		}
+
+		for _, id := range m.Defaults[t] {
+			if app, ok := byDesktopID[id]; ok {
+				add(app)
+			}
+		}
+
+		for _, id := range m.Added[t] {
+			if app, ok := byDesktopID[id]; ok {
+				add(app)
+			}
+		}
+
+		for _, app := range apps {
+			if removed[app.DesktopFileID()] {
+				continue
+			}
+
+			for _, appType := range app.MimeTypes {
+				if canonicalMimeType(appType) == t {
+					add(app)
+					break
+				}
+			}
+		}
+	}
+
+	return handlers
+}
+
+func getXDGConfigDirs() string {
+	xdgConfigDirs := os.Getenv("XDG_CONFIG_DIRS")
+	if xdgConfigDirs == "" {
+		xdgConfigDirs = defaultXDGConfigDirs
+	}
+
+	return xdgConfigDirs
+}
+
+func getXDGConfigHome() (string, error) {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", errors.New("error getting home directory: HOME environment variable not set")
+	}
+
+	xdgConfigHome := os.Getenv("XDG_CONFIG_HOME")
+	if xdgConfigHome == "" {
+		xdgConfigHome = defaultXDGConfigHome
+	}
+
+	if strings.HasPrefix(xdgConfigHome, "~") {
+		xdgConfigHome = home + xdgConfigHome[1:]
+	}
+
+	return xdgConfigHome, nil
+}
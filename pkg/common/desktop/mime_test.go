@@ -0,0 +1,138 @@
+package desktop
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// Point the data and config directories at temporary ones, and return the
+// root they are in. The shared MIME-info database is read again from there.
+func useTempMimeDirs(t *testing.T) string {
+	t.Helper()
+
+	tmp := t.TempDir()
+	t.Setenv("HOME", tmp)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(tmp, "data"))
+	t.Setenv("XDG_DATA_DIRS", filepath.Join(tmp, "usr", "share"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmp, "config"))
+	t.Setenv("XDG_CONFIG_DIRS", filepath.Join(tmp, "etc"))
+	t.Setenv("XDG_CURRENT_DESKTOP", "Test")
+
+	mimeDatabaseOnce = sync.Once{}
+	t.Cleanup(func() {
+		mimeDatabaseOnce = sync.Once{}
+	})
+
+	return tmp
+}
+
+func writeTestFile(t *testing.T, file string, contents string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMimeAppsPrecedence(t *testing.T) {
+	tmp := useTempMimeDirs(t)
+
+	// Highest precedence first
+	files := map[string]string{
+		"config/test-mimeapps.list": "[Default Applications]\ntext/plain=editor.desktop\n",
+		"config/mimeapps.list": "[Added Associations]\ntext/plain=notes.desktop\n\n" +
+			"[Removed Associations]\ntext/plain=viewer.desktop;\n",
+
+		// Removing notes.desktop here doesn't undo adding it above
+		"etc/mimeapps.list": "[Default Applications]\ntext/plain=viewer.desktop;pager.desktop\n\n" +
+			"[Added Associations]\ntext/plain=viewer.desktop;extra.desktop\n\n" +
+			"[Removed Associations]\ntext/plain=notes.desktop;other.desktop\n",
+
+		// Nor does removing it here undo adding it in the same file
+		"usr/share/applications/mimeapps.list": "[Added Associations]\ntext/plain=other.desktop;late.desktop\n\n" +
+			"[Removed Associations]\ntext/plain=late.desktop\n",
+	}
+	for name, contents := range files {
+		writeTestFile(t, filepath.Join(tmp, name), contents)
+	}
+
+	m := ReadMimeApps()
+
+	if want := []string{"editor.desktop", "pager.desktop"}; !slices.Equal(m.Defaults["text/plain"], want) {
+		t.Errorf("defaults %v, want %v", m.Defaults["text/plain"], want)
+	}
+	if want := []string{"notes.desktop", "extra.desktop", "late.desktop"}; !slices.Equal(m.Added["text/plain"], want) {
+		t.Errorf("added %v, want %v", m.Added["text/plain"], want)
+	}
+
+	app := func(id string, mimeTypes ...string) App {
+		return App{ID: id, Filename: "/usr/share/applications/" + id + ".desktop", MimeTypes: mimeTypes}
+	}
+	apps := []App{
+		app("other", "text/plain"),
+		app("viewer", "text/plain"),
+		app("editor", "text/plain"),
+		app("notes"),
+		app("pager"),
+		app("extra"),
+		app("late"),
+		app("images", "image/png"),
+	}
+
+	handlers := make([]string, 0)
+	for _, h := range m.Handlers(apps, "text/plain") {
+		handlers = append(handlers, h.ID)
+	}
+
+	// Removed associations apply to the MimeType keys whichever file they are
+	// in, so other.desktop and viewer.desktop are left out
+	if want := []string{"editor", "pager", "notes", "extra", "late"}; !slices.Equal(handlers, want) {
+		t.Errorf("handlers %v, want %v", handlers, want)
+	}
+}
+
+func TestMimeTypeFromGlobs(t *testing.T) {
+	tmp := useTempMimeDirs(t)
+
+	writeTestFile(t, filepath.Join(tmp, "data", "mime", "globs2"), `# weight:type:pattern[:flags]
+50:text/x-csrc:*.c
+50:text/x-c++src:*.C:cs
+50:text/x-c++src:*.cc
+50:application/gzip:*.gz
+50:application/x-compressed-tar:*.tar.gz
+10:text/x-readme:README*
+`)
+	writeTestFile(t, filepath.Join(tmp, "usr", "share", "mime", "globs2"), `50:text/plain:*.txt
+50:text/markdown:*.md
+`)
+
+	tests := map[string]string{
+		"main.c":  "text/x-csrc",
+		"MAIN.CC": "text/x-c++src",
+
+		// A pattern that matches with the same case beats one that matches
+		// ignoring case
+		"main.C": "text/x-c++src",
+
+		"NOTES.TXT": "text/plain",
+
+		// The higher weight wins, then the longer pattern
+		"README.md":    "text/markdown",
+		"README":       "text/x-readme",
+		"dist.tar.gz":  "application/x-compressed-tar",
+		"dist.gz":      "application/gzip",
+		"unknown.xyz1": "",
+	}
+
+	for name, want := range tests {
+		if got := mimeTypeFromGlobs(name); got != want {
+			t.Errorf("mimeTypeFromGlobs(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package desktop
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Returns why the application should be left out of menus, or "" if it should
+// be shown
+func (a App) HiddenReason() string {
+	if a.Hidden {
+		return "Hidden"
+	}
+
+	if a.NoDisplay {
+		return "NoDisplay"
+	}
+
+	return a.unavailableReason()
+}
+
+// Returns true if the application may be used to open files: unlike
+// HiddenReason, this allows applications that set NoDisplay, which are often
+// handlers that aren't meant to be started on their own
+func (a App) CanOpenFiles() bool {
+	return !a.Hidden && a.unavailableReason() == ""
+}
+
+// Returns why the application can't be used at all in this session, or "" if
+// it can
+func (a App) unavailableReason() string {
+	if a.TryExec != "" && !isExecutable(a.TryExec) {
+		return "TryExec " + a.TryExec + " not found"
+	}
+
+	desktops := currentDesktops()
+
+	if len(a.OnlyShowIn) > 0 && !slices.ContainsFunc(desktops, func(d string) bool { return slices.Contains(a.OnlyShowIn, d) }) {
+		return "OnlyShowIn " + strings.Join(a.OnlyShowIn, ";")
+	}
+
+	if slices.ContainsFunc(desktops, func(d string) bool { return slices.Contains(a.NotShowIn, d) }) {
+		return "NotShowIn " + strings.Join(a.NotShowIn, ";")
+	}
+
+	return ""
+}
+
+// Returns the names of the current desktop environment, from
+// $XDG_CURRENT_DESKTOP, which may list more than one (e.g. "ubuntu:GNOME")
+func currentDesktops() []string {
+	desktops := make([]string, 0)
+	for _, d := range strings.Split(os.Getenv("XDG_CURRENT_DESKTOP"), ":") {
+		if d != "" {
+			desktops = append(desktops, d)
+		}
+	}
+
+	return desktops
+}
+
+// Returns true if program is an executable file, or the name of one in the
+// PATH
+func isExecutable(program string) bool {
+	if !path.IsAbs(program) {
+		_, err := exec.LookPath(program)
+		return err == nil
+	}
+
+	info, err := os.Stat(program)
+	return err == nil && !info.IsDir() && info.Mode()&0o111 != 0
+}
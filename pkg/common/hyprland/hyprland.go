@@ -0,0 +1,376 @@
+package hyprland
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/compositor"
+	"github.com/jplein/launchit/pkg/common/logger"
+)
+
+const (
+	// Socket for request/response commands, relative to the instance directory
+	commandSocket = ".socket.sock"
+
+	// Socket that Hyprland writes events to, relative to the instance directory
+	eventSocket = ".socket2.sock"
+
+	requestTimeout = 2 * time.Second
+)
+
+// Hyprland implements compositor.Compositor by talking to Hyprland's IPC
+// sockets
+type Hyprland struct {
+	dir string
+}
+
+// Create a client for the Hyprland instance identified by
+// $HYPRLAND_INSTANCE_SIGNATURE
+func New() (*Hyprland, error) {
+	signature := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+	if signature == "" {
+		return nil, errors.New("error connecting to Hyprland: HYPRLAND_INSTANCE_SIGNATURE is not set")
+	}
+
+	return NewWithDir(instanceDir(signature)), nil
+}
+
+// Create a client for the Hyprland instance whose sockets are in dir
+func NewWithDir(dir string) *Hyprland {
+	return &Hyprland{dir: dir}
+}
+
+// Hyprland 0.40 and later put their sockets under $XDG_RUNTIME_DIR; earlier
+// versions use /tmp
+func instanceDir(signature string) string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		dir := path.Join(runtimeDir, "hypr", signature)
+		if _, err := os.Stat(path.Join(dir, commandSocket)); err == nil {
+			return dir
+		}
+	}
+
+	return path.Join("/tmp", "hypr", signature)
+}
+
+func (h *Hyprland) Name() string {
+	return "Hyprland"
+}
+
+type workspaceRef struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type client struct {
+	Address        string       `json:"address"`
+	Mapped         bool         `json:"mapped"`
+	Workspace      workspaceRef `json:"workspace"`
+	Class          string       `json:"class"`
+	Title          string       `json:"title"`
+	FocusHistoryID int          `json:"focusHistoryID"`
+}
+
+type workspace struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Monitor string `json:"monitor"`
+}
+
+type monitor struct {
+	Name            string       `json:"name"`
+	Focused         bool         `json:"focused"`
+	ActiveWorkspace workspaceRef `json:"activeWorkspace"`
+}
+
+// Returns windows in most-recently-focused order
+func (h *Hyprland) ListWindows() ([]compositor.Window, error) {
+	var clients []client
+	if err := h.requestJSON("clients", &clients); err != nil {
+		return nil, fmt.Errorf("error getting windows from Hyprland: %w", err)
+	}
+
+	sort.SliceStable(clients, func(i, j int) bool {
+		return clients[i].FocusHistoryID < clients[j].FocusHistoryID
+	})
+
+	windows := make([]compositor.Window, 0, len(clients))
+	for _, c := range clients {
+		if !c.Mapped {
+			continue
+		}
+
+		id, err := parseAddress(c.Address)
+		if err != nil {
+			logger.Log("error reading Hyprland window address: %v\n", err)
+			continue
+		}
+
+		windows = append(windows, compositor.Window{
+			ID:          id,
+			Title:       c.Title,
+			AppID:       c.Class,
+			WorkspaceID: c.Workspace.ID,
+			IsFocused:   c.FocusHistoryID == 0,
+		})
+	}
+
+	return windows, nil
+}
+
+func (h *Hyprland) FocusWindow(id uint64) error {
+	if err := h.dispatch(fmt.Sprintf("focuswindow address:0x%x", id)); err != nil {
+		return fmt.Errorf("error switching to window %d: %w", id, err)
+	}
+
+	return nil
+}
+
+// Returns regular workspaces sorted by ID. Special workspaces (which have
+// negative IDs) are omitted.
+func (h *Hyprland) ListWorkspaces() ([]compositor.Workspace, error) {
+	var workspaces []workspace
+	if err := h.requestJSON("workspaces", &workspaces); err != nil {
+		return nil, fmt.Errorf("error getting workspaces from Hyprland: %w", err)
+	}
+
+	var monitors []monitor
+	if err := h.requestJSON("monitors", &monitors); err != nil {
+		return nil, fmt.Errorf("error getting monitors from Hyprland: %w", err)
+	}
+
+	active := make(map[int64]bool)
+	focused := make(map[int64]bool)
+	for _, m := range monitors {
+		active[m.ActiveWorkspace.ID] = true
+		if m.Focused {
+			focused[m.ActiveWorkspace.ID] = true
+		}
+	}
+
+	result := make([]compositor.Workspace, 0, len(workspaces))
+	for _, w := range workspaces {
+		if w.ID <= 0 {
+			continue
+		}
+
+		result = append(result, compositor.Workspace{
+			ID:        w.ID,
+			Index:     int(w.ID),
+			Name:      w.Name,
+			Output:    w.Monitor,
+			IsActive:  active[w.ID],
+			IsFocused: focused[w.ID],
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result, nil
+}
+
+func (h *Hyprland) SwitchWorkspace(index int) error {
+	if err := h.dispatch(fmt.Sprintf("workspace %d", index)); err != nil {
+		return fmt.Errorf("error switching to workspace %d: %w", index, err)
+	}
+
+	return nil
+}
+
+func (h *Hyprland) MoveWindowToWorkspace(index int) error {
+	if err := h.dispatch(fmt.Sprintf("movetoworkspace %d", index)); err != nil {
+		return fmt.Errorf("error moving window to workspace %d: %w", index, err)
+	}
+
+	return nil
+}
+
+// Send a raw command to the command socket and return the response
+func (h *Hyprland) request(command string) ([]byte, error) {
+	socket := path.Join(h.dir, commandSocket)
+	conn, err := net.DialTimeout("unix", socket, requestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", socket, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if _, err := conn.Write([]byte(command)); err != nil {
+		return nil, fmt.Errorf("error sending '%s' to %s: %w", command, socket, err)
+	}
+
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response to '%s' from %s: %w", command, socket, err)
+	}
+
+	return resp, nil
+}
+
+func (h *Hyprland) requestJSON(command string, v any) error {
+	resp, err := h.request("j/" + command)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(resp, v); err != nil {
+		logger.Log("hyprland %s JSON output:\n", command)
+		logger.Log("%s\n", string(resp))
+		return fmt.Errorf("error parsing JSON: %w", err)
+	}
+
+	return nil
+}
+
+func (h *Hyprland) dispatch(args string) error {
+	resp, err := h.request("dispatch " + args)
+	if err != nil {
+		return err
+	}
+
+	if r := strings.TrimSpace(string(resp)); r != "ok" {
+		return fmt.Errorf("dispatch %s failed: %s", args, r)
+	}
+
+	return nil
+}
+
+// Convert a Hyprland window address, with or without the 0x prefix, to an
+// integer
+func parseAddress(address string) (uint64, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(address, "0x"), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window address '%s': %w", address, err)
+	}
+
+	return id, nil
+}
+
+type eventStream struct {
+	h       *Hyprland
+	conn    net.Conn
+	scanner *bufio.Scanner
+	pending []compositor.Event
+}
+
+func (h *Hyprland) EventStream() (compositor.EventStream, error) {
+	socket := path.Join(h.dir, eventSocket)
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", socket, err)
+	}
+
+	s := &eventStream{h: h, conn: conn, scanner: bufio.NewScanner(conn)}
+
+	// Match Niri's behaviour of starting the stream with the full window and
+	// workspace lists
+	if err := s.queueWindows(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := s.queueWorkspaces(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *eventStream) Next() (compositor.Event, error) {
+	for len(s.pending) == 0 {
+		if !s.scanner.Scan() {
+			if err := s.scanner.Err(); err != nil {
+				return compositor.Event{}, fmt.Errorf("error reading from Hyprland event socket: %w", err)
+			}
+			return compositor.Event{}, io.EOF
+		}
+
+		if err := s.handleLine(s.scanner.Text()); err != nil {
+			logger.Log("error handling Hyprland event: %v\n", err)
+		}
+	}
+
+	e := s.pending[0]
+	s.pending = s.pending[1:]
+	return e, nil
+}
+
+func (s *eventStream) Close() error {
+	return s.conn.Close()
+}
+
+// Translate a line of the form EVENT>>DATA into zero or more compositor events
+func (s *eventStream) handleLine(line string) error {
+	name, data, ok := strings.Cut(line, ">>")
+	if !ok {
+		return nil
+	}
+
+	switch name {
+	case "activewindowv2":
+		if data == "" || data == "," {
+			s.pending = append(s.pending, compositor.Event{WindowFocusChanged: &compositor.WindowFocusChanged{}})
+			return nil
+		}
+
+		id, err := parseAddress(data)
+		if err != nil {
+			return err
+		}
+		s.pending = append(s.pending, compositor.Event{WindowFocusChanged: &compositor.WindowFocusChanged{ID: &id}})
+	case "closewindow":
+		id, err := parseAddress(data)
+		if err != nil {
+			return err
+		}
+		s.pending = append(s.pending, compositor.Event{WindowClosed: &compositor.WindowClosed{ID: id}})
+	case "urgent":
+		id, err := parseAddress(data)
+		if err != nil {
+			return err
+		}
+		s.pending = append(s.pending, compositor.Event{WindowUrgencyChanged: &compositor.WindowUrgencyChanged{ID: id, Urgent: true}})
+	case "openwindow", "windowtitlev2", "movewindowv2":
+		// These events carry only some of a window's properties, so refresh
+		// the whole list
+		return s.queueWindows()
+	case "workspacev2", "focusedmonv2", "createworkspacev2", "destroyworkspacev2", "moveworkspacev2", "renameworkspace":
+		return s.queueWorkspaces()
+	}
+
+	return nil
+}
+
+func (s *eventStream) queueWindows() error {
+	windows, err := s.h.ListWindows()
+	if err != nil {
+		return err
+	}
+
+	s.pending = append(s.pending, compositor.Event{WindowsChanged: &compositor.WindowsChanged{Windows: windows}})
+	return nil
+}
+
+func (s *eventStream) queueWorkspaces() error {
+	workspaces, err := s.h.ListWorkspaces()
+	if err != nil {
+		return err
+	}
+
+	s.pending = append(s.pending, compositor.Event{WorkspacesChanged: &compositor.WorkspacesChanged{Workspaces: workspaces}})
+	return nil
+}
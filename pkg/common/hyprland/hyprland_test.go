@@ -0,0 +1,268 @@
+package hyprland
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jplein/launchit/pkg/common/compositor"
+)
+
+// A stand-in for Hyprland's sockets: it answers requests on the command socket
+// with canned replies, and hands the test the connection to the event socket
+type fakeHyprland struct {
+	clients    []client
+	workspaces []workspace
+	monitors   []monitor
+
+	// The reply to dispatch commands
+	dispatchReply string
+
+	// The dispatch commands received, without "dispatch "
+	dispatched []string
+
+	// Connections to the event socket
+	events chan net.Conn
+
+	mu sync.Mutex
+}
+
+func startFake(t *testing.T) (*fakeHyprland, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	f := &fakeHyprland{dispatchReply: "ok", events: make(chan net.Conn, 1)}
+
+	commands, err := net.Listen("unix", path.Join(dir, commandSocket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { commands.Close() })
+
+	events, err := net.Listen("unix", path.Join(dir, eventSocket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { events.Close() })
+
+	go func() {
+		for {
+			conn, err := commands.Accept()
+			if err != nil {
+				return
+			}
+			f.serve(t, conn)
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := events.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			f.events <- conn
+		}
+	}()
+
+	return f, dir
+}
+
+// Answer one request on the command socket
+func (f *fakeHyprland) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Errorf("error reading request: %v", err)
+		return
+	}
+	command := string(buf[:n])
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var reply any
+	switch command {
+	case "j/clients":
+		reply = f.clients
+	case "j/workspaces":
+		reply = f.workspaces
+	case "j/monitors":
+		reply = f.monitors
+	default:
+		if args, ok := strings.CutPrefix(command, "dispatch "); ok {
+			f.dispatched = append(f.dispatched, args)
+			io.WriteString(conn, f.dispatchReply)
+			return
+		}
+
+		t.Errorf("unexpected request '%s'", command)
+		io.WriteString(conn, "unknown request")
+		return
+	}
+
+	json.NewEncoder(conn).Encode(reply)
+}
+
+// Change the canned replies
+func (f *fakeHyprland) update(change func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	change()
+}
+
+func TestEventStream(t *testing.T) {
+	f, dir := startFake(t)
+
+	foot := client{Address: "0x55a1", Mapped: true, Workspace: workspaceRef{ID: 1, Name: "1"}, Class: "foot", Title: "~", FocusHistoryID: 0}
+	hidden := client{Address: "0x55ff", Mapped: false, Workspace: workspaceRef{ID: 1, Name: "1"}, Class: "xdg-desktop-portal-gtk"}
+
+	f.update(func() {
+		f.clients = []client{hidden, foot}
+		f.workspaces = []workspace{
+			{ID: 2, Name: "2", Monitor: "DP-1"},
+			{ID: -98, Name: "special:scratchpad", Monitor: "DP-1"},
+			{ID: 1, Name: "1", Monitor: "DP-1"},
+		}
+		f.monitors = []monitor{{Name: "DP-1", Focused: true, ActiveWorkspace: workspaceRef{ID: 1, Name: "1"}}}
+	})
+
+	stream, err := NewWithDir(dir).EventStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	events := <-f.events
+
+	next := func() compositor.Event {
+		t.Helper()
+
+		event, err := stream.Next()
+		if err != nil {
+			t.Fatalf("error reading event: %v", err)
+		}
+
+		return event
+	}
+
+	send := func(line string) {
+		t.Helper()
+
+		if _, err := io.WriteString(events, line+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := func(what string, got compositor.Event, want compositor.Event) {
+		t.Helper()
+
+		if !reflect.DeepEqual(got, want) {
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(want)
+			t.Errorf("%s: got %s, want %s", what, gotJSON, wantJSON)
+		}
+	}
+
+	footWindow := compositor.Window{ID: 0x55a1, Title: "~", AppID: "foot", WorkspaceID: 1, IsFocused: true}
+
+	// The stream starts with the window and workspace lists
+	expect("initial windows", next(), compositor.Event{WindowsChanged: &compositor.WindowsChanged{
+		Windows: []compositor.Window{footWindow},
+	}})
+	expect("initial workspaces", next(), compositor.Event{WorkspacesChanged: &compositor.WorkspacesChanged{
+		Workspaces: []compositor.Workspace{
+			{ID: 1, Index: 1, Name: "1", Output: "DP-1", IsActive: true, IsFocused: true},
+			{ID: 2, Index: 2, Name: "2", Output: "DP-1"},
+		},
+	}})
+
+	// A window opening refreshes the window list, most recently focused first
+	f.update(func() {
+		f.clients[1].FocusHistoryID = 1
+		f.clients = append(f.clients, client{Address: "0x55b2", Mapped: true, Workspace: workspaceRef{ID: 1, Name: "1"}, Class: "firefox", Title: "Mozilla Firefox", FocusHistoryID: 0})
+	})
+	send("openwindow>>55b2,1,firefox,Mozilla Firefox")
+
+	footWindow.IsFocused = false
+	expect("openwindow", next(), compositor.Event{WindowsChanged: &compositor.WindowsChanged{
+		Windows: []compositor.Window{
+			{ID: 0x55b2, Title: "Mozilla Firefox", AppID: "firefox", WorkspaceID: 1, IsFocused: true},
+			footWindow,
+		},
+	}})
+
+	id := uint64(0x55b2)
+	send("activewindow>>firefox,Mozilla Firefox")
+	send("activewindowv2>>55b2")
+	expect("activewindowv2", next(), compositor.Event{WindowFocusChanged: &compositor.WindowFocusChanged{ID: &id}})
+
+	send("activewindowv2>>,")
+	expect("activewindowv2 without a window", next(), compositor.Event{WindowFocusChanged: &compositor.WindowFocusChanged{}})
+
+	send("urgent>>55a1")
+	expect("urgent", next(), compositor.Event{WindowUrgencyChanged: &compositor.WindowUrgencyChanged{ID: 0x55a1, Urgent: true}})
+
+	send("closewindow>>55a1")
+	expect("closewindow", next(), compositor.Event{WindowClosed: &compositor.WindowClosed{ID: 0x55a1}})
+
+	// Hyprland sends both versions of the workspace event, which should
+	// refresh the workspace list once
+	f.update(func() {
+		f.monitors[0].ActiveWorkspace = workspaceRef{ID: 2, Name: "2"}
+	})
+	send("workspace>>2")
+	send("workspacev2>>2,2")
+	expect("workspace", next(), compositor.Event{WorkspacesChanged: &compositor.WorkspacesChanged{
+		Workspaces: []compositor.Workspace{
+			{ID: 1, Index: 1, Name: "1", Output: "DP-1"},
+			{ID: 2, Index: 2, Name: "2", Output: "DP-1", IsActive: true, IsFocused: true},
+		},
+	}})
+
+	events.Close()
+	if event, err := stream.Next(); err != io.EOF {
+		t.Errorf("got %+v, %v after the event socket closed, want io.EOF", event, err)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	f, dir := startFake(t)
+	h := NewWithDir(dir)
+
+	if err := h.FocusWindow(0x55b2); err != nil {
+		t.Errorf("FocusWindow: %v", err)
+	}
+	if err := h.SwitchWorkspace(3); err != nil {
+		t.Errorf("SwitchWorkspace: %v", err)
+	}
+	if err := h.MoveWindowToWorkspace(2); err != nil {
+		t.Errorf("MoveWindowToWorkspace: %v", err)
+	}
+
+	f.update(func() { f.dispatchReply = "Invalid dispatcher" })
+	if err := h.SwitchWorkspace(4); err == nil {
+		t.Errorf("SwitchWorkspace succeeded when Hyprland replied with an error")
+	}
+
+	want := []string{
+		"focuswindow address:0x55b2",
+		"workspace 3",
+		"movetoworkspace 2",
+		"workspace 4",
+	}
+
+	f.update(func() {
+		if !reflect.DeepEqual(f.dispatched, want) {
+			t.Errorf("dispatched %q, want %q", f.dispatched, want)
+		}
+	})
+}
@@ -0,0 +1,331 @@
+package icon
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jplein/launchit/pkg/common/config"
+	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/common/state"
+	"github.com/jplein/launchit/pkg/common/state/locations"
+)
+
+// File extensions of icons, in order of preference
+var extensions = []string{".png", ".svg", ".xpm"}
+
+// Finds the files for icon names, following the Icon Theme Specification.
+// Lookups are remembered across runs, so that listing entries doesn't have to
+// scan the icon themes every time.
+type Resolver struct {
+	size  int
+	scale int
+
+	// The user's theme and the themes it inherits from, then hicolor
+	themes   []*theme
+	baseDirs []string
+
+	// Directories of icons outside any theme, like /usr/share/pixmaps
+	pixmapDirs []string
+
+	// The files in each theme directory, by base directory and directory
+	// name. Filled in when first needed, since scanning a large theme is slow.
+	files map[string]map[string]bool
+
+	cache       iconCache
+	cacheLoaded bool
+	dirty       bool
+}
+
+// The lookups saved to disk. The fingerprint records the state of the theme
+// directories the lookups were made in; when they change, e.g. because an icon
+// theme was installed, the lookups are discarded.
+type iconCache struct {
+	Fingerprint string            `json:"fingerprint"`
+	Paths       map[string]string `json:"paths"`
+}
+
+// Create a resolver for the theme and size set in the config, or the theme from
+// the GTK settings
+func NewResolver() *Resolver {
+	icons := config.GetOrDefault().Icons
+
+	name := icons.Theme
+	if name == "" {
+		name = gtkTheme()
+	}
+	if name == "" {
+		name = fallbackTheme
+	}
+
+	return newResolver(name, icons.SizeValue())
+}
+
+func newResolver(theme string, size int) *Resolver {
+	r := &Resolver{
+		size:       size,
+		scale:      1,
+		baseDirs:   baseDirs(),
+		pixmapDirs: pixmapDirs(),
+		files:      make(map[string]map[string]bool),
+	}
+
+	r.themes = r.themeChain(theme)
+
+	return r
+}
+
+// Returns the theme, the themes it inherits from, depth first, and hicolor
+// last, skipping themes that aren't installed
+func (r *Resolver) themeChain(name string) []*theme {
+	chain := make([]*theme, 0)
+	seen := make(map[string]bool)
+
+	var add func(name string)
+	add = func(name string) {
+		if seen[name] || name == fallbackTheme {
+			return
+		}
+		seen[name] = true
+
+		t := readTheme(name, r.baseDirs)
+		if t == nil {
+			logger.Log("icon theme %s not found\n", name)
+			return
+		}
+
+		chain = append(chain, t)
+		for _, parent := range t.Inherits {
+			add(parent)
+		}
+	}
+
+	add(name)
+
+	if t := readTheme(fallbackTheme, r.baseDirs); t != nil {
+		chain = append(chain, t)
+	}
+
+	return chain
+}
+
+// Returns the path of the icon with the given name, or "" if there is none.
+// Names that are already paths are returned if the file exists. Names that
+// aren't found are retried without their last dash-separated part, so
+// view-grid-symbolic-fill falls back to view-grid-symbolic, then view-grid.
+func (r *Resolver) Lookup(name string) string {
+	if name == "" {
+		return ""
+	}
+
+	if path.IsAbs(name) {
+		if _, err := os.Stat(name); err != nil {
+			return ""
+		}
+		return name
+	}
+
+	r.loadCache()
+
+	if p, ok := r.cache.Paths[name]; ok {
+		if p == "" {
+			return ""
+		}
+
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+
+	p := ""
+	for candidate := name; candidate != ""; {
+		if p = r.find(candidate); p != "" {
+			break
+		}
+
+		i := strings.LastIndex(candidate, "-")
+		if i < 0 {
+			break
+		}
+		candidate = candidate[:i]
+	}
+
+	if p == "" {
+		logger.Log("icon %s not found\n", name)
+	}
+
+	r.cache.Paths[name] = p
+	r.dirty = true
+
+	return p
+}
+
+// Look the icon up in each theme in turn, then outside any theme
+func (r *Resolver) find(name string) string {
+	for _, t := range r.themes {
+		if p := r.lookupInTheme(name, t); p != "" {
+			return p
+		}
+	}
+
+	return r.lookupFallback(name)
+}
+
+// Returns the icon from the theme directory that matches the size, or else the
+// one with the closest size
+func (r *Resolver) lookupInTheme(name string, t *theme) string {
+	closest := ""
+	minDistance := math.MaxInt
+
+	for _, dir := range t.Directories {
+		matches := dir.matchesSize(r.size, r.scale)
+		distance := dir.sizeDistance(r.size, r.scale)
+		if !matches && distance >= minDistance {
+			continue
+		}
+
+		for _, base := range r.baseDirs {
+			themeDir := path.Join(base, t.Name, dir.Name)
+			for _, ext := range extensions {
+				if !r.hasFile(themeDir, name+ext) {
+					continue
+				}
+
+				p := path.Join(themeDir, name+ext)
+				if matches {
+					return p
+				}
+
+				if distance < minDistance {
+					closest = p
+					minDistance = distance
+				}
+			}
+		}
+	}
+
+	return closest
+}
+
+// Returns an icon that's directly in one of the base directories, or in one of
+// the pixmaps directories
+func (r *Resolver) lookupFallback(name string) string {
+	for _, dirs := range [][]string{r.baseDirs, r.pixmapDirs} {
+		for _, dir := range dirs {
+			for _, ext := range extensions {
+				if r.hasFile(dir, name+ext) {
+					return path.Join(dir, name+ext)
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// Returns true if the directory contains the file, reading the directory the
+// first time it's needed
+func (r *Resolver) hasFile(dir string, file string) bool {
+	files, ok := r.files[dir]
+	if !ok {
+		files = make(map[string]bool)
+		if entries, err := os.ReadDir(dir); err == nil {
+			for _, e := range entries {
+				files[e.Name()] = true
+			}
+		}
+		r.files[dir] = files
+	}
+
+	return files[file]
+}
+
+// Summarize the theme and size, and the modification times of every directory
+// icons are looked up in
+func (r *Resolver) fingerprint() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d@%d;", r.size, r.scale)
+
+	stamp := func(dir string) {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(&sb, "%s:%d;", dir, info.ModTime().UnixNano())
+	}
+
+	for _, base := range r.baseDirs {
+		stamp(base)
+	}
+
+	for _, t := range r.themes {
+		for _, base := range r.baseDirs {
+			stamp(path.Join(base, t.Name))
+			for _, dir := range t.Directories {
+				stamp(path.Join(base, t.Name, dir.Name))
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+// Read the saved lookups, discarding them if the theme directories changed
+func (r *Resolver) loadCache() {
+	if r.cacheLoaded {
+		return
+	}
+	r.cacheLoaded = true
+
+	fingerprint := r.fingerprint()
+	r.cache = iconCache{Fingerprint: fingerprint, Paths: make(map[string]string)}
+
+	file, err := locations.IconCacheFilename()
+	if err != nil {
+		logger.Log("error reading icon cache: %v\n", err)
+		return
+	}
+
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	cache := iconCache{}
+	if err := json.Unmarshal(buf, &cache); err != nil {
+		logger.Log("error reading icon cache: error parsing %s as JSON: %v\n", file, err)
+		return
+	}
+
+	if cache.Fingerprint == fingerprint && cache.Paths != nil {
+		r.cache = cache
+	}
+}
+
+// Save the lookups made since the resolver was created, if there are new ones
+func (r *Resolver) Save() error {
+	if !r.dirty {
+		return nil
+	}
+
+	file, err := locations.IconCacheFilename()
+	if err != nil {
+		return fmt.Errorf("error saving icon cache: %w", err)
+	}
+
+	buf, err := json.Marshal(r.cache)
+	if err != nil {
+		return fmt.Errorf("error saving icon cache: error marshaling to JSON: %w", err)
+	}
+
+	if err := state.WriteFileAtomic(file, buf, 0o644); err != nil {
+		return fmt.Errorf("error saving icon cache: %w", err)
+	}
+
+	r.dirty = false
+
+	return nil
+}
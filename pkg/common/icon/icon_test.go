@@ -0,0 +1,251 @@
+package icon
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Write a file, creating its directory
+func writeFile(t *testing.T, file string, contents string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Install icon themes in a temporary home directory and data directory, and
+// return them
+func useTempThemes(t *testing.T) (home string, dataDir string) {
+	t.Helper()
+
+	tmp := t.TempDir()
+	home = filepath.Join(tmp, "home")
+	dataDir = filepath.Join(tmp, "usr", "share")
+
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, ".local", "share"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("XDG_DATA_DIRS", dataDir)
+
+	// The user's theme, with a scaled directory that mustn't be chosen at
+	// scale 1
+	writeFile(t, filepath.Join(home, ".icons", "Child", "index.theme"), `[Icon Theme]
+Name=Child
+Inherits=Parent,Other
+Directories=48x48/apps,24x24/apps,unsized,undescribed
+ScaledDirectories=48x48@2/apps
+
+[48x48/apps]
+Size=48
+Type=Fixed
+
+[24x24/apps]
+Size=24
+Type=Fixed
+
+[48x48@2/apps]
+Size=48
+Scale=2
+Type=Fixed
+
+[unsized]
+Type=Fixed
+`)
+
+	writeFile(t, filepath.Join(dataDir, "icons", "Parent", "index.theme"), `[Icon Theme]
+Name=Parent
+Inherits=Grandparent
+Directories=32x32/apps,scalable/apps
+
+[32x32/apps]
+Size=32
+
+[scalable/apps]
+Size=64
+MinSize=16
+MaxSize=256
+Type=Scalable
+`)
+
+	for _, name := range []string{"Grandparent", "Other", "hicolor"} {
+		writeFile(t, filepath.Join(dataDir, "icons", name, "index.theme"), `[Icon Theme]
+Name=`+name+`
+Directories=48x48/apps
+
+[48x48/apps]
+Size=48
+Type=Fixed
+`)
+	}
+
+	icons := map[string]string{
+		"Child/48x48/apps/exact.png":         filepath.Join(home, ".icons"),
+		"Child/24x24/apps/exact.png":         filepath.Join(home, ".icons"),
+		"Child/24x24/apps/closest.png":       filepath.Join(home, ".icons"),
+		"Child/48x48@2/apps/closest.png":     filepath.Join(home, ".icons"),
+		"Child/24x24/apps/shadowed.png":      filepath.Join(home, ".icons"),
+		"Child/48x48/apps/view-grid.png":     filepath.Join(home, ".icons"),
+		"Parent/scalable/apps/inherited.svg": filepath.Join(dataDir, "icons"),
+		"Parent/scalable/apps/shadowed.svg":  filepath.Join(dataDir, "icons"),
+		"Grandparent/48x48/apps/deep.png":    filepath.Join(dataDir, "icons"),
+		"Other/48x48/apps/deep.png":          filepath.Join(dataDir, "icons"),
+		"hicolor/48x48/apps/fallback.png":    filepath.Join(dataDir, "icons"),
+	}
+	for name, base := range icons {
+		writeFile(t, filepath.Join(base, name), "")
+	}
+
+	// Outside any theme
+	writeFile(t, filepath.Join(dataDir, "pixmaps", "legacy.xpm"), "")
+
+	return home, dataDir
+}
+
+func TestLookup(t *testing.T) {
+	home, dataDir := useTempThemes(t)
+
+	r := newResolver("Child", 48)
+
+	names := make([]string, 0, len(r.themes))
+	for _, theme := range r.themes {
+		names = append(names, theme.Name)
+	}
+	if want := []string{"Child", "Parent", "Grandparent", "Other", "hicolor"}; !slices.Equal(names, want) {
+		t.Errorf("theme chain %v, want %v", names, want)
+	}
+
+	child := filepath.Join(home, ".icons", "Child")
+	icons := filepath.Join(dataDir, "icons")
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "exact", want: filepath.Join(child, "48x48/apps/exact.png")},
+		{name: "closest", want: filepath.Join(child, "24x24/apps/closest.png")},
+
+		// A theme's closest icon comes before an exact one in the theme it
+		// inherits from
+		{name: "shadowed", want: filepath.Join(child, "24x24/apps/shadowed.png")},
+
+		{name: "inherited", want: filepath.Join(icons, "Parent/scalable/apps/inherited.svg")},
+		{name: "deep", want: filepath.Join(icons, "Grandparent/48x48/apps/deep.png")},
+		{name: "fallback", want: filepath.Join(icons, "hicolor/48x48/apps/fallback.png")},
+		{name: "legacy", want: filepath.Join(dataDir, "pixmaps/legacy.xpm")},
+		{name: "view-grid-symbolic-fill", want: filepath.Join(child, "48x48/apps/view-grid.png")},
+		{name: "missing", want: ""},
+		{name: filepath.Join(child, "24x24/apps/exact.png"), want: filepath.Join(child, "24x24/apps/exact.png")},
+		{name: filepath.Join(child, "missing.png"), want: ""},
+	}
+
+	for _, test := range tests {
+		if got := r.Lookup(test.name); got != test.want {
+			t.Errorf("Lookup(%s) = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReadTheme(t *testing.T) {
+	home, _ := useTempThemes(t)
+
+	theme := readTheme("Parent", baseDirs())
+	if theme == nil {
+		t.Fatal("theme Parent not found")
+	}
+
+	want := []directory{
+		// Type and Threshold default to Threshold and 2
+		{Name: "32x32/apps", Size: 32, Scale: 1, Type: typeThreshold, MinSize: 32, MaxSize: 32, Threshold: 2},
+		{Name: "scalable/apps", Size: 64, Scale: 1, Type: typeScalable, MinSize: 16, MaxSize: 256, Threshold: 2},
+	}
+	if !slices.Equal(theme.Directories, want) {
+		t.Errorf("Parent has directories %+v, want %+v", theme.Directories, want)
+	}
+
+	// Directories without a size or a section are left out, and scaled
+	// directories are read too
+	theme = readTheme("Child", []string{filepath.Join(home, ".icons")})
+	if theme == nil {
+		t.Fatal("theme Child not found")
+	}
+
+	names := make([]string, 0, len(theme.Directories))
+	for _, dir := range theme.Directories {
+		names = append(names, dir.Name)
+	}
+	if want := []string{"48x48/apps", "24x24/apps", "48x48@2/apps"}; !slices.Equal(names, want) {
+		t.Errorf("Child has directories %v, want %v", names, want)
+	}
+
+	if theme := readTheme("Missing", baseDirs()); theme != nil {
+		t.Errorf("missing theme read as %+v", theme)
+	}
+}
+
+func TestSizes(t *testing.T) {
+	fixed := directory{Size: 48, Scale: 1, Type: typeFixed}
+	scaled := directory{Size: 48, Scale: 2, Type: typeFixed}
+	scalable := directory{Size: 64, Scale: 1, Type: typeScalable, MinSize: 16, MaxSize: 256}
+	threshold := directory{Size: 32, Scale: 1, Type: typeThreshold, Threshold: 2}
+
+	tests := []struct {
+		dir      directory
+		size     int
+		scale    int
+		matches  bool
+		distance int
+	}{
+		{dir: fixed, size: 48, scale: 1, matches: true, distance: 0},
+		{dir: fixed, size: 32, scale: 1, matches: false, distance: 16},
+		{dir: fixed, size: 48, scale: 2, matches: false, distance: 48},
+		{dir: scaled, size: 48, scale: 2, matches: true, distance: 0},
+		{dir: scaled, size: 48, scale: 1, matches: false, distance: 48},
+		{dir: scalable, size: 128, scale: 1, matches: true, distance: 0},
+		{dir: scalable, size: 8, scale: 1, matches: false, distance: 8},
+		{dir: scalable, size: 512, scale: 1, matches: false, distance: 256},
+		{dir: threshold, size: 34, scale: 1, matches: true, distance: 0},
+		{dir: threshold, size: 48, scale: 1, matches: false, distance: 16},
+	}
+
+	for _, test := range tests {
+		if got := test.dir.matchesSize(test.size, test.scale); got != test.matches {
+			t.Errorf("%+v matches size %d@%d: %v, want %v", test.dir, test.size, test.scale, got, test.matches)
+		}
+		if got := test.dir.sizeDistance(test.size, test.scale); got != test.distance {
+			t.Errorf("%+v is %d from size %d@%d, want %d", test.dir, got, test.size, test.scale, test.distance)
+		}
+	}
+}
+
+// Saved lookups are used until a theme directory changes
+func TestCache(t *testing.T) {
+	home, _ := useTempThemes(t)
+
+	r := newResolver("Child", 48)
+	if got := r.Lookup("late"); got != "" {
+		t.Fatalf("Lookup(late) = %s before it was installed", got)
+	}
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = newResolver("Child", 48)
+	r.loadCache()
+	if p, ok := r.cache.Paths["late"]; !ok || p != "" {
+		t.Errorf("saved lookup of late is %q, %v, want it saved as not found", p, ok)
+	}
+
+	late := filepath.Join(home, ".icons", "Child", "48x48/apps/late.png")
+	writeFile(t, late, "")
+
+	r = newResolver("Child", 48)
+	if got := r.Lookup("late"); got != late {
+		t.Errorf("Lookup(late) = %q after it was installed, want %s", got, late)
+	}
+}
@@ -0,0 +1,226 @@
+package icon
+
+import (
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// The theme every other theme falls back to
+	fallbackTheme = "hicolor"
+
+	defaultXDGDataDirs = "/usr/local/share:/usr/share"
+
+	// Where icons that aren't in any theme are traditionally installed
+	defaultPixmapsDir = "/usr/share/pixmaps"
+)
+
+// Kinds of theme directory, from the Type key
+const (
+	typeFixed     = "Fixed"
+	typeScalable  = "Scalable"
+	typeThreshold = "Threshold"
+)
+
+// A directory of icons in a theme, e.g. 48x48/apps
+type directory struct {
+	Name      string
+	Size      int
+	Scale     int
+	Type      string
+	MinSize   int
+	MaxSize   int
+	Threshold int
+}
+
+// Returns true if icons in the directory are suitable for the size as they are
+func (d directory) matchesSize(size int, scale int) bool {
+	if d.Scale != scale {
+		return false
+	}
+
+	switch d.Type {
+	case typeFixed:
+		return d.Size == size
+	case typeScalable:
+		return d.MinSize <= size && size <= d.MaxSize
+	default:
+		return d.Size-d.Threshold <= size && size <= d.Size+d.Threshold
+	}
+}
+
+// Returns how far the directory's icons are from the size, in pixels
+func (d directory) sizeDistance(size int, scale int) int {
+	switch d.Type {
+	case typeFixed:
+		return abs(d.Size*d.Scale - size*scale)
+	case typeScalable:
+		if size*scale < d.MinSize*d.Scale {
+			return d.MinSize*d.Scale - size*scale
+		}
+		if size*scale > d.MaxSize*d.Scale {
+			return size*scale - d.MaxSize*d.Scale
+		}
+		return 0
+	default:
+		if size*scale < (d.Size-d.Threshold)*d.Scale || size*scale > (d.Size+d.Threshold)*d.Scale {
+			return abs(d.Size*d.Scale - size*scale)
+		}
+		return 0
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// An icon theme, read from its index.theme
+type theme struct {
+	Name        string
+	Inherits    []string
+	Directories []directory
+}
+
+// Returns the directories that icons and themes are looked up in, highest
+// precedence first: ~/.icons, then the icons directory of each XDG data
+// directory
+func baseDirs() []string {
+	dirs := make([]string, 0)
+
+	home := os.Getenv("HOME")
+	if home != "" {
+		dirs = append(dirs, path.Join(home, ".icons"))
+	}
+
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" && home != "" {
+		dataHome = path.Join(home, ".local/share")
+	}
+	if dataHome != "" {
+		dirs = append(dirs, path.Join(dataHome, "icons"))
+	}
+
+	for _, dir := range dataDirs() {
+		dirs = append(dirs, path.Join(dir, "icons"))
+	}
+
+	return dirs
+}
+
+// Returns the directories of icons that aren't in any theme: the pixmaps
+// directory of each XDG data directory, and /usr/share/pixmaps. They are only
+// looked in once no theme has the icon.
+func pixmapDirs() []string {
+	dirs := make([]string, 0)
+	for _, dir := range dataDirs() {
+		dirs = append(dirs, path.Join(dir, "pixmaps"))
+	}
+
+	if !slices.Contains(dirs, defaultPixmapsDir) {
+		dirs = append(dirs, defaultPixmapsDir)
+	}
+
+	return dirs
+}
+
+// Returns the XDG data directories, other than the user's
+func dataDirs() []string {
+	dataDirs := os.Getenv("XDG_DATA_DIRS")
+	if dataDirs == "" {
+		dataDirs = defaultXDGDataDirs
+	}
+
+	return splitList(dataDirs, ":")
+}
+
+// Read a theme's index.theme from the first base directory that has one.
+// Returns nil if the theme isn't installed.
+func readTheme(name string, baseDirs []string) *theme {
+	for _, base := range baseDirs {
+		file := path.Join(base, name, "index.theme")
+		f, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true, KeyValueDelimiters: "="}, file)
+		if err != nil {
+			continue
+		}
+
+		section := f.Section("Icon Theme")
+		t := &theme{
+			Name:        name,
+			Inherits:    splitList(section.Key("Inherits").String(), ","),
+			Directories: make([]directory, 0),
+		}
+
+		dirNames := splitList(section.Key("Directories").String(), ",")
+		dirNames = append(dirNames, splitList(section.Key("ScaledDirectories").String(), ",")...)
+
+		for _, dirName := range dirNames {
+			s, err := f.GetSection(dirName)
+			if err != nil {
+				continue
+			}
+
+			size := s.Key("Size").MustInt(0)
+			if size <= 0 {
+				continue
+			}
+
+			t.Directories = append(t.Directories, directory{
+				Name:      dirName,
+				Size:      size,
+				Scale:     s.Key("Scale").MustInt(1),
+				Type:      s.Key("Type").In(typeThreshold, []string{typeFixed, typeScalable, typeThreshold}),
+				MinSize:   s.Key("MinSize").MustInt(size),
+				MaxSize:   s.Key("MaxSize").MustInt(size),
+				Threshold: s.Key("Threshold").MustInt(2),
+			})
+		}
+
+		return t
+	}
+
+	return nil
+}
+
+// Returns the name of the icon theme chosen in the GTK settings, or "" if none
+// is set
+func gtkTheme() string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return ""
+		}
+		configHome = path.Join(home, ".config")
+	}
+
+	for _, dir := range []string{"gtk-4.0", "gtk-3.0"} {
+		f, err := ini.LoadSources(ini.LoadOptions{Loose: true}, path.Join(configHome, dir, "settings.ini"))
+		if err != nil {
+			continue
+		}
+
+		if name := f.Section("Settings").Key("gtk-icon-theme-name").String(); name != "" {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func splitList(s string, separator string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(s, separator) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
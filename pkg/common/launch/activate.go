@@ -0,0 +1,106 @@
+package launch
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jplein/launchit/pkg/common/dbus"
+	"github.com/jplein/launchit/pkg/common/logger"
+)
+
+// The interface D-Bus activatable applications implement, from the Desktop
+// Entry Specification
+const applicationInterface = "org.freedesktop.Application"
+
+// Start an application, or bring it to the front, by calling it over D-Bus
+// rather than running its Exec line. The session bus starts the application if
+// it isn't running.
+//
+// id: The desktop file ID without .desktop, which is also the application's
+// bus name, e.g. org.gnome.Nautilus
+//
+// uris: Files and URLs to open. If there are any, they are passed to Open,
+// otherwise the application is activated.
+func Activate(id string, uris []string) error {
+	var body dbus.Encoder
+	member := "Activate"
+	signature := "a{sv}"
+
+	if len(uris) > 0 {
+		member = "Open"
+		signature = "as" + signature
+		body.StringArray(fileURIs(uris))
+	}
+	body.StringDict(platformData())
+
+	return callApplication(id, member, signature, body.Bytes())
+}
+
+// Run one of an application's actions by calling it over D-Bus
+//
+// id: The desktop file ID without .desktop, as for Activate
+//
+// action: The action identifier, as listed in the Actions key
+func ActivateAction(id string, action string) error {
+	var body dbus.Encoder
+	body.String(action)
+	body.Array(1, func() {})
+	body.StringDict(platformData())
+
+	return callApplication(id, "ActivateAction", "sava{sv}", body.Bytes())
+}
+
+func callApplication(id string, member string, signature string, body []byte) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return fmt.Errorf("error activating %s: %w", id, err)
+	}
+	defer conn.Close()
+
+	logger.Log("calling %s.%s on %s\n", applicationInterface, member, id)
+
+	if err := conn.Call(id, objectPath(id), applicationInterface, member, signature, body); err != nil {
+		return fmt.Errorf("error activating %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// Returns the object path of an application, which is its bus name with dots
+// replaced by slashes and dashes by underscores, e.g. /org/gnome/Nautilus
+func objectPath(id string) string {
+	return "/" + strings.NewReplacer(".", "/", "-", "_").Replace(id)
+}
+
+// Returns the platform data to pass to the application, so that it can take
+// focus: the activation token (Wayland) or startup notification ID (X11) that
+// launchit was started with, if any
+func platformData() map[string]string {
+	data := make(map[string]string)
+
+	if token := os.Getenv("XDG_ACTIVATION_TOKEN"); token != "" {
+		data["activation-token"] = token
+	}
+
+	if id := os.Getenv("DESKTOP_STARTUP_ID"); id != "" {
+		data["desktop-startup-id"] = id
+	}
+
+	return data
+}
+
+// Convert local paths to file:// URIs, which is what Open takes. URLs are
+// passed through as they are.
+func fileURIs(uris []string) []string {
+	converted := make([]string, 0, len(uris))
+	for _, uri := range uris {
+		if !strings.Contains(uri, "://") {
+			uri = (&url.URL{Scheme: "file", Path: uri}).String()
+		}
+		converted = append(converted, uri)
+	}
+
+	return converted
+}
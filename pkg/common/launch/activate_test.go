@@ -0,0 +1,249 @@
+package launch
+
+import (
+	"bufio"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/dbus"
+)
+
+// The application the tests activate, which dbus-test-tool stands in for
+const testAppID = "org.example.App"
+
+// Start a private session bus with a service standing in for the application,
+// and return a channel of the lines dbus-monitor prints for the calls made to
+// org.freedesktop.Application and the replies to them
+func startBus(t *testing.T) <-chan string {
+	t.Helper()
+
+	for _, tool := range []string{"dbus-daemon", "dbus-test-tool", "dbus-monitor"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s isn't installed", tool)
+		}
+	}
+
+	daemon := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address")
+	stdout, err := daemon.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.Start(); err != nil {
+		t.Skipf("error starting dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		daemon.Process.Kill()
+		daemon.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Skipf("error reading the bus address from dbus-daemon: %v", err)
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", strings.TrimSpace(address))
+
+	// Keep the log out of the user's data directory
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	app := exec.Command("dbus-test-tool", "echo", "--name="+testAppID)
+	if err := app.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		app.Process.Kill()
+		app.Wait()
+	})
+
+	monitor := exec.Command("dbus-monitor", "interface="+applicationInterface, "type=method_return")
+	output, err := monitor.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := monitor.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		monitor.Process.Kill()
+		monitor.Wait()
+	})
+
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	// dbus-monitor loses the name it is given once it becomes a monitor
+	for line := range lines {
+		if strings.Contains(line, "member=NameLost") {
+			break
+		}
+	}
+
+	waitForName(t, testAppID)
+
+	return lines
+}
+
+// Wait for a service to own its name on the bus
+func waitForName(t *testing.T, name string) {
+	t.Helper()
+
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := conn.Call(name, "/", "org.freedesktop.DBus.Peer", "Ping", "", nil)
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s didn't start: %v", name, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Returns the header and the arguments of the next call to the member that
+// dbus-monitor printed, with runs of spaces collapsed
+func nextCall(t *testing.T, lines <-chan string, member string) (string, []string) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	var header string
+	var args []string
+
+	for {
+		var line string
+		select {
+		case l, ok := <-lines:
+			if !ok {
+				t.Fatalf("dbus-monitor exited before printing a call to %s", member)
+			}
+			line = l
+		case <-timeout:
+			t.Fatalf("dbus-monitor didn't print a call to %s", member)
+		}
+
+		// Arguments are indented, and end at the next message
+		if strings.HasPrefix(line, " ") {
+			if header != "" {
+				args = append(args, strings.Join(strings.Fields(line), " "))
+			}
+			continue
+		}
+
+		if header != "" {
+			return header, args
+		}
+
+		if strings.HasPrefix(line, "method call ") && strings.HasSuffix(line, "member="+member) {
+			header = line
+		}
+	}
+}
+
+func TestActivate(t *testing.T) {
+	lines := startBus(t)
+
+	t.Setenv("XDG_ACTIVATION_TOKEN", "token")
+	t.Setenv("DESKTOP_STARTUP_ID", "")
+
+	platformData := []string{
+		"array [",
+		"dict entry(",
+		`string "activation-token"`,
+		`variant string "token"`,
+		")",
+		"]",
+	}
+
+	tests := []struct {
+		name   string
+		call   func() error
+		member string
+		args   []string
+	}{
+		{
+			name:   "Activate",
+			call:   func() error { return Activate(testAppID, nil) },
+			member: "Activate",
+			args:   platformData,
+		},
+		{
+			name:   "Open",
+			call:   func() error { return Activate(testAppID, []string{"/tmp/a file.txt", "https://example.com/"}) },
+			member: "Open",
+			args: append([]string{
+				"array [",
+				`string "file:///tmp/a%20file.txt"`,
+				`string "https://example.com/"`,
+				"]",
+			}, platformData...),
+		},
+		{
+			name:   "ActivateAction",
+			call:   func() error { return ActivateAction(testAppID, "new-window") },
+			member: "ActivateAction",
+			args: append([]string{
+				`string "new-window"`,
+				"array [",
+				"]",
+			}, platformData...),
+		},
+	}
+
+	for _, test := range tests {
+		if err := test.call(); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		header, args := nextCall(t, lines, test.member)
+
+		wantHeader := "destination=" + testAppID + " "
+		if !strings.Contains(header, wantHeader) || !strings.Contains(header, "path=/org/example/App;") {
+			t.Errorf("%s: called %s, want a call to %s at /org/example/App", test.name, header, testAppID)
+		}
+
+		if strings.Join(args, "\n") != strings.Join(test.args, "\n") {
+			t.Errorf("%s: called with arguments\n%s\nwant\n%s", test.name, strings.Join(args, "\n"), strings.Join(test.args, "\n"))
+		}
+	}
+}
+
+func TestActivateNotRunning(t *testing.T) {
+	startBus(t)
+
+	// No service file installs this name, so the bus can't start it
+	err := Activate("org.example.Missing", nil)
+
+	var dbusErr *dbus.Error
+	if !errors.As(err, &dbusErr) || dbusErr.Name != "org.freedesktop.DBus.Error.ServiceUnknown" {
+		t.Errorf("activating a missing application returned %v, want org.freedesktop.DBus.Error.ServiceUnknown", err)
+	}
+}
+
+func TestObjectPath(t *testing.T) {
+	tests := map[string]string{
+		"org.gnome.Nautilus":       "/org/gnome/Nautilus",
+		"org.example.my-app":       "/org/example/my_app",
+		"io.github.user.Some-Tool": "/io/github/user/Some_Tool",
+	}
+
+	for id, want := range tests {
+		if got := objectPath(id); got != want {
+			t.Errorf("objectPath(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package launch
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/jplein/launchit/pkg/common/config"
+	"github.com/jplein/launchit/pkg/common/logger"
+)
+
+// Ways of starting a program, chosen with launch.method in the config
+const (
+	// Start the program in a new session, with its output going to the log,
+	// so that it outlives launchit and isn't tied to its terminal
+	MethodSetsid = "setsid"
+
+	// Start the program in its own systemd scope, with systemd-run --user
+	// --scope, so that it gets its own cgroup instead of sharing the
+	// launcher's. Its output goes to the journal.
+	MethodSystemdRun = "systemd-run"
+
+	// Start the program with uwsm app, which puts it in a systemd scope named
+	// the way uwsm sessions expect
+	MethodUWSM = "uwsm"
+
+	defaultMethod = MethodSetsid
+)
+
+// What is being started, used to name its systemd unit
+type Options struct {
+	// The desktop file ID of the application without .desktop, e.g.
+	// org.gnome.Nautilus, or another name for what is being started, e.g. a
+	// command ID
+	ID string
+
+	// A human-readable name for what is being started, used as the unit's
+	// description
+	Name string
+
+	// The working directory. Empty means launchit's working directory.
+	Dir string
+}
+
+// Start a program and return without waiting for it, using the launch method
+// from the config. The program is found in PATH, and run directly, not
+// through a shell.
+//
+// argv: The program and its arguments
+func Start(argv []string, options Options) error {
+	if len(argv) == 0 {
+		return fmt.Errorf("error starting %s: no program to run", options.ID)
+	}
+
+	// Check this first, since once the program is detached (or started by a
+	// wrapper) a missing program can't be reported
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return fmt.Errorf("error starting %s: %w", options.ID, err)
+	}
+
+	method := configuredMethod()
+
+	switch method {
+	case MethodSystemdRun:
+		if wrapper, err := exec.LookPath("systemd-run"); err == nil {
+			args := []string{
+				wrapper, "--user", "--scope", "--quiet", "--collect",
+				"--unit=" + unitName(options.ID),
+			}
+			if options.Name != "" {
+				args = append(args, "--description="+options.Name)
+			}
+			args = append(args, "--")
+
+			// A scope takes the output of the process started in it, so have
+			// systemd-cat send it to the journal, where it is recorded under
+			// the scope
+			if cat, err := exec.LookPath("systemd-cat"); err == nil {
+				args = append(args, cat, "--identifier="+identifier(options))
+			}
+
+			argv = append(args, argv...)
+		} else {
+			logger.Log("launch method %s is set but systemd-run isn't installed, using %s\n", method, MethodSetsid)
+		}
+	case MethodUWSM:
+		if wrapper, err := exec.LookPath("uwsm"); err == nil {
+			args := []string{wrapper, "app"}
+			if options.ID != "" {
+				args = append(args, "-a", options.ID)
+			}
+			argv = append(append(args, "--"), argv...)
+		} else {
+			logger.Log("launch method %s is set but uwsm isn't installed, using %s\n", method, MethodSetsid)
+		}
+	}
+
+	logger.Log("starting %v\n", argv)
+
+	return detach(argv, options.Dir)
+}
+
+// Returns the launch method from the config, or the default if it isn't set
+// or is unknown
+func configuredMethod() string {
+	method := config.GetOrDefault().Launch.Method
+
+	switch method {
+	case MethodSetsid, MethodSystemdRun, MethodUWSM:
+		return method
+	case "":
+		return defaultMethod
+	default:
+		logger.Log("invalid launch method '%s' in config, using %s\n", method, defaultMethod)
+		return defaultMethod
+	}
+}
+
+// Start a process in a new session, and don't wait for it. Once launchit
+// exits, the process is adopted by init (or the session's subreaper), as if it
+// had been double-forked. Its input is /dev/null, and its output goes to the
+// log, so that a program that fails to start leaves a trace.
+func detach(argv []string, dir string) error {
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("error starting %s: %w", argv[0], err)
+	}
+	defer devNull.Close()
+
+	output := openOutput()
+	if output == nil {
+		output = devNull
+	} else {
+		defer output.Close()
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Stdin = devNull
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting %s with arguments %v: %w", argv[0], argv[1:], err)
+	}
+
+	// Reap the process if it exits while launchit is still running, and report
+	// it if it failed
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			logger.Log("%s exited: %v\n", argv[0], err)
+		}
+	}()
+
+	return nil
+}
+
+// Open the log for started programs to write their output to, or return nil
+// if it can't be opened. It is opened separately, for appending, so that their
+// writes land at the end of the file even after a later run of launchit
+// truncates it.
+func openOutput() *os.File {
+	file, err := logger.Filename()
+	if err != nil {
+		logger.Log("error opening the log for program output: %v\n", err)
+		return nil
+	}
+
+	fh, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		logger.Log("error opening the log for program output: %v\n", err)
+		return nil
+	}
+
+	return fh
+}
+
+// Returns the name that systemd-cat tags the program's output with in the
+// journal
+func identifier(options Options) string {
+	if options.ID == "" {
+		return "launchit"
+	}
+
+	return options.ID
+}
+
+// Returns a unit name for a scope, following the systemd convention for
+// applications started by a launcher: app-<id>-<random>.scope
+func unitName(id string) string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+
+	if id == "" {
+		id = "launchit"
+	}
+
+	return "app-" + escapeUnitName(id) + "-" + hex.EncodeToString(buf) + ".scope"
+}
+
+// Escape characters that aren't allowed in a unit name, and dashes, which
+// separate the parts of the name, as \xNN, like systemd-escape does
+func escapeUnitName(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '_', c == ':', c == '.' && i > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+
+	return b.String()
+}
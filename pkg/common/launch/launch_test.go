@@ -0,0 +1,58 @@
+package launch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/logger"
+)
+
+// A detached program that fails should leave its output in the log
+func TestDetachedOutputGoesToLog(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	file, err := logger.Filename()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	err = Start([]string{"sh", "-c", "echo 'cannot open display' >&2; exit 1"}, Options{ID: "broken"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		buf, _ := os.ReadFile(file)
+		if strings.Contains(string(buf), "cannot open display") {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("log %s doesn't contain the program's output:\n%s", file, buf)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestEscapeUnitName(t *testing.T) {
+	tests := map[string]string{
+		"org.gnome.Nautilus": "org.gnome.Nautilus",
+		"code-url-handler":   `code\x2durl\x2dhandler`,
+		".hidden":            `\x2ehidden`,
+		"my app":             `my\x20app`,
+	}
+
+	for id, want := range tests {
+		if got := escapeUnitName(id); got != want {
+			t.Errorf("escapeUnitName(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
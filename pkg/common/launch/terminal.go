@@ -0,0 +1,103 @@
+package launch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/jplein/launchit/pkg/common/config"
+	"github.com/jplein/launchit/pkg/common/logger"
+)
+
+// Runs a command in the user's preferred terminal, from the xdg-terminal-exec
+// proposal
+const xdgTerminalExec = "xdg-terminal-exec"
+
+// Terminals to look for in PATH when none is configured, in order of
+// preference
+var knownTerminals = []string{
+	"foot",
+	"kitty",
+	"alacritty",
+	"wezterm",
+	"ghostty",
+	"konsole",
+	"gnome-terminal",
+	"kgx",
+	"ptyxis",
+	"xfce4-terminal",
+	"mate-terminal",
+	"terminator",
+	"urxvt",
+	"st",
+	"xterm",
+}
+
+// The arguments that come between each terminal and the command it should
+// run. Terminals not listed here take -e, like xterm.
+var terminalArgs = map[string][]string{
+	xdgTerminalExec:  {},
+	"foot":           {"--"},
+	"kitty":          {"--"},
+	"wezterm":        {"start", "--"},
+	"gnome-terminal": {"--"},
+	"kgx":            {"--"},
+	"ptyxis":         {"--"},
+	"xfce4-terminal": {"-x"},
+	"mate-terminal":  {"-x"},
+	"terminator":     {"-x"},
+}
+
+// Returns the command that runs argv in a terminal window
+//
+// The terminal is the one set in launch.terminal in the config, or else
+// xdg-terminal-exec if it is installed, or else $TERMINAL, or else the first
+// of a list of well-known terminals that is installed. The configured
+// terminal and $TERMINAL may include arguments, e.g. "kitty --single-instance".
+func InTerminal(argv []string) ([]string, error) {
+	terminal, err := findTerminal()
+	if err != nil {
+		return nil, fmt.Errorf("error finding a terminal to run %s in: %w", argv[0], err)
+	}
+
+	args, ok := terminalArgs[path.Base(terminal[0])]
+	if !ok {
+		args = []string{"-e"}
+	}
+
+	wrapped := make([]string, 0, len(terminal)+len(args)+len(argv))
+	wrapped = append(wrapped, terminal...)
+	wrapped = append(wrapped, args...)
+	wrapped = append(wrapped, argv...)
+
+	return wrapped, nil
+}
+
+// Returns the terminal command, split into the program and its arguments
+func findTerminal() ([]string, error) {
+	if terminal := strings.Fields(config.GetOrDefault().Launch.Terminal); len(terminal) > 0 {
+		return terminal, nil
+	}
+
+	if _, err := exec.LookPath(xdgTerminalExec); err == nil {
+		return []string{xdgTerminalExec}, nil
+	}
+
+	if terminal := strings.Fields(os.Getenv("TERMINAL")); len(terminal) > 0 {
+		if _, err := exec.LookPath(terminal[0]); err == nil {
+			return terminal, nil
+		}
+		logger.Log("terminal %s from $TERMINAL isn't installed, looking for another\n", terminal[0])
+	}
+
+	for _, terminal := range knownTerminals {
+		if _, err := exec.LookPath(terminal); err == nil {
+			return []string{terminal}, nil
+		}
+	}
+
+	return nil, errors.New("no terminal found: set launch.terminal in the config, or $TERMINAL")
+}
@@ -1,14 +1,21 @@
 package launcher
 
 import (
+	"cmp"
 	"fmt"
 	"io"
+	"net/url"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/jplein/launchit/pkg/common/icon"
 	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/common/server/client"
 	"github.com/jplein/launchit/pkg/common/source"
 	"github.com/jplein/launchit/pkg/common/state"
+	"github.com/jplein/launchit/pkg/common/wm"
 )
 
 type Launcher struct {
@@ -27,34 +34,87 @@ func (l *Launcher) List(sortRecent bool) ([]source.Entry, error) {
 		return nil, fmt.Errorf("error listing entries: %w", err)
 	}
 
-	recents, err := state.Get()
+	if sortRecent {
+		SortByUsage(entries)
+	}
+
+	return entries, nil
+}
+
+// Sort entries in place by frecency, a combination of how often and how
+// recently they were chosen, boosted for entries chosen in the current context.
+// Entries that haven't been chosen keep their relative order, after the ones
+// that have.
+func SortByUsage(entries []source.Entry) {
+	SortByUsageKey(entries, func(id string) string { return id })
+}
+
+// Sort entries in place by frecency, as SortByUsage does, looking up each
+// entry's usage by the ID that key returns for it
+func SortByUsageKey(entries []source.Entry, key func(id string) string) {
+	db, err := state.Load()
 	if err != nil {
-		logger.Log("error reading recents: %v", err)
+		logger.Log("error reading usage: %v", err)
+		return
 	}
 
-	if recents != nil && sortRecent {
-		slices.SortFunc(entries, func(a, b source.Entry) int {
-			indexA := slices.Index(recents, a.ID)
-			indexB := slices.Index(recents, b.ID)
+	score := db.Scorer(time.Now(), CurrentContext())
+	scores := make(map[string]float64, len(entries))
+	for _, e := range entries {
+		scores[e.ID] = score(key(e.ID))
+	}
 
-			// Both found in recents: sort by index (lower index comes first)
-			if indexA != -1 && indexB != -1 {
-				return indexA - indexB
-			}
+	slices.SortStableFunc(entries, func(a, b source.Entry) int {
+		// Higher scores come first
+		return cmp.Compare(scores[b.ID], scores[a.ID])
+	})
+}
 
-			// Only a is in recents: a comes first
-			if indexA != -1 {
-				return -1
-			}
+// Returns the focused workspace and window, for recording and ranking entries.
+// Parts that can't be determined are left empty.
+func CurrentContext() state.Context {
+	ctx := state.Context{}
 
-			// Only b is in recents: b comes first
-			if indexB != -1 {
-				return 1
-			}
+	windows, err := wm.ListWindows(false)
+	if err != nil {
+		logger.Log("error getting focused window: %v\n", err)
+	}
+	for _, w := range windows {
+		if w.IsFocused {
+			ctx.FocusedAppID = w.AppID
+			break
+		}
+	}
+
+	workspaces, err := wm.ListWorkspaces()
+	if err != nil {
+		logger.Log("error getting focused workspace: %v\n", err)
+	}
+	for _, w := range workspaces {
+		if w.IsFocused {
+			ctx.Workspace = w.Key()
+			break
+		}
+	}
+
+	return ctx
+}
 
-			// Neither in recents: maintain original order
-			return 0
-		})
+// Fetch the entry list from `launchit server`, which keeps it precomputed
+//
+// sourceName: If not empty, only return entries from the source with this name
+//
+// sortRecent: Whether to sort by usage, as SortByUsage does
+func ListFromServer(sourceName string, sortRecent bool) ([]source.Entry, error) {
+	query := url.Values{}
+	if sourceName != "" {
+		query.Set("source", sourceName)
+	}
+	query.Set("sort", strconv.FormatBool(sortRecent))
+
+	entries := []source.Entry{}
+	if err := client.Get("/api/v1/entries?"+query.Encode(), &entries); err != nil {
+		return nil, err
 	}
 
 	return entries, nil
@@ -66,6 +126,33 @@ func (l *Launcher) Write(writer io.Writer, columns []string, widths []int, showI
 		return err
 	}
 
+	return WriteEntries(writer, entries, columns, widths, showIcons)
+}
+
+// Icon formats for WriteEntries
+const (
+	// Icon names, for launchers that look icons up themselves, like rofi
+	IconFormatName = "name"
+
+	// Absolute paths to icon files, for launchers that only load files
+	IconFormatPath = "path"
+)
+
+// Replace each entry's icon name with the path of the icon file in the current
+// icon theme. Entries whose icon isn't found are left without an icon.
+func ResolveIconPaths(entries []source.Entry) {
+	resolver := icon.NewResolver()
+	for i := range entries {
+		entries[i].Icon = resolver.Lookup(entries[i].Icon)
+	}
+
+	if err := resolver.Save(); err != nil {
+		logger.Log("%v\n", err)
+	}
+}
+
+// Write entries in the format expected by dmenu-style launchers, one per line
+func WriteEntries(writer io.Writer, entries []source.Entry, columns []string, widths []int, showIcons *bool) error {
 	for _, entry := range entries {
 		_, err := writer.Write([]byte(getLine(entry, columns, widths, showIcons) + "\n"))
 		if err != nil {
@@ -115,6 +202,10 @@ func getDescription(entry source.Entry, columns []string, widths []int) string {
 			part = cleanDescriptionPart(entry.Description)
 		case colType:
 			part = cleanDescriptionPart(entry.Type)
+		case colComment:
+			part = cleanDescriptionPart(entry.Comment)
+		case colGeneric:
+			part = cleanDescriptionPart(entry.Generic)
 		case "":
 			part = cleanDescriptionPart(entry.Description)
 		default:
@@ -142,12 +233,14 @@ func cleanDescriptionPart(s string) string {
 }
 
 const (
-	colName = "name"
-	colType = "type"
+	colName    = "name"
+	colType    = "type"
+	colComment = "comment"
+	colGeneric = "generic"
 )
 
 func ValidColumnNames() []string {
-	return []string{colName, colType}
+	return []string{colName, colType, colComment, colGeneric}
 }
 
 func IsValidColumnName(s string) bool {
@@ -40,6 +40,11 @@ func Log(format string, a ...any) {
 	fmt.Fprintf(fh, timestampedMessage)
 }
 
+// Returns the log file for this subcommand
+func Filename() (string, error) {
+	return getLogFile()
+}
+
 func getLogFile() (string, error) {
 	if logFile != "" {
 		return logFile, nil
@@ -1,183 +1,338 @@
 package niri
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
-	"os/exec"
-	"sort"
+	"net"
+	"os"
+	"time"
 
+	"github.com/jplein/launchit/pkg/common/compositor"
 	"github.com/jplein/launchit/pkg/common/logger"
-	"github.com/jplein/launchit/pkg/common/server"
 )
 
 type WindowDescription struct {
-	ID    int    `json:"id"`
-	Title string `json:"title"`
-	AppID string `json:"app_id"`
+	ID          uint64  `json:"id"`
+	Title       string  `json:"title"`
+	AppID       string  `json:"app_id"`
+	WorkspaceID *uint64 `json:"workspace_id"`
+	IsFocused   bool    `json:"is_focused"`
+	IsUrgent    bool    `json:"is_urgent"`
 }
 
-func ListWindows(sortWindows bool) ([]WindowDescription, error) {
-	var stdout, stderr bytes.Buffer
-	cmd := exec.Command("niri", "msg", "--json", "windows")
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+type WorkspaceDescription struct {
+	ID        uint64  `json:"id"`
+	Index     int     `json:"idx"`
+	Name      *string `json:"name"`
+	Output    *string `json:"output"`
+	IsActive  bool    `json:"is_active"`
+	IsFocused bool    `json:"is_focused"`
+}
 
-	if err := cmd.Run(); err != nil {
-		if stdout.Len() > 0 {
-			logger.Log("niri windows stdout: %s\n", stdout.String())
-		}
-		if stderr.Len() > 0 {
-			logger.Log("niri windows stderr: %s\n", stderr.String())
-		}
-		return nil, fmt.Errorf("error getting windows from Niri: %w", err)
-	}
+const requestTimeout = 2 * time.Second
 
-	listBytes := stdout.Bytes()
+// Niri implements compositor.Compositor by talking to a running Niri instance
+// over its IPC socket
+type Niri struct {
+	socket string
+}
 
-	windows := make([]WindowDescription, 0)
-	if err := json.Unmarshal(listBytes, &windows); err != nil {
-		logger.Log("niri window list JSON output:\n")
-		logger.Log(string(listBytes))
-		return nil, fmt.Errorf("error getting windows from Niri: error parsing JSON: %w", err)
+// Create a client for the Niri instance listening on $NIRI_SOCKET
+func New() (*Niri, error) {
+	socket := os.Getenv("NIRI_SOCKET")
+	if socket == "" {
+		return nil, errors.New("error connecting to Niri: NIRI_SOCKET is not set")
 	}
 
-	if sortWindows {
-		history := []uint64{}
-		serverHistory, err := historyFromServer()
-		if err != nil {
-			logger.Log("error getting history from server: %v", err)
-		} else {
-			history = serverHistory
-		}
+	return NewWithSocket(socket), nil
+}
+
+// Create a client for the Niri instance listening on socket
+func NewWithSocket(socket string) *Niri {
+	return &Niri{socket: socket}
+}
+
+func (n *Niri) Name() string {
+	return "Niri"
+}
+
+func (n *Niri) ListWindows() ([]compositor.Window, error) {
+	var reply struct {
+		Windows []WindowDescription `json:"Windows"`
+	}
+	if err := n.request("Windows", &reply); err != nil {
+		return nil, fmt.Errorf("error getting windows from Niri: %w", err)
+	}
 
-		sortWindowsByHistory(windows, history)
+	result := make([]compositor.Window, 0, len(reply.Windows))
+	for _, w := range reply.Windows {
+		result = append(result, w.toWindow())
 	}
 
-	return windows, nil
+	return result, nil
 }
 
-func FocusWindow(windowID int) error {
-	windowStr := fmt.Sprintf("%d", windowID)
+func (n *Niri) FocusWindow(windowID uint64) error {
+	action := map[string]any{"FocusWindow": map[string]any{"id": windowID}}
+	if err := n.action(action); err != nil {
+		return fmt.Errorf("error switching to window %d: %w", windowID, err)
+	}
 
-	var stdout, stderr bytes.Buffer
-	cmd := exec.Command("niri", "msg", "action", "focus-window", "--id", windowStr)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	return nil
+}
 
-	if err := cmd.Run(); err != nil {
-		if stdout.Len() > 0 {
-			logger.Log("niri focus-window stdout: %s\n", stdout.String())
-		}
-		if stderr.Len() > 0 {
-			logger.Log("niri focus-window stderr: %s\n", stderr.String())
-		}
-		return fmt.Errorf("error switching to window %s: %w", windowID, err)
+func (n *Niri) ListWorkspaces() ([]compositor.Workspace, error) {
+	var reply struct {
+		Workspaces []WorkspaceDescription `json:"Workspaces"`
+	}
+	if err := n.request("Workspaces", &reply); err != nil {
+		return nil, fmt.Errorf("error getting workspaces from Niri: %w", err)
 	}
 
-	if stdout.Len() > 0 {
-		logger.Log("niri focus-window stdout: %s\n", stdout.String())
+	result := make([]compositor.Workspace, 0, len(reply.Workspaces))
+	for _, w := range reply.Workspaces {
+		result = append(result, w.toWorkspace())
 	}
-	if stderr.Len() > 0 {
-		logger.Log("niri focus-window stderr: %s\n", stderr.String())
+
+	return result, nil
+}
+
+func (n *Niri) SwitchWorkspace(index int) error {
+	action := map[string]any{"FocusWorkspace": map[string]any{
+		"reference": map[string]any{"Index": index},
+	}}
+	if err := n.action(action); err != nil {
+		return fmt.Errorf("error switching to workspace %d: %w", index, err)
 	}
 
 	return nil
 }
 
-type WorkspaceDescription struct {
-	ID        int     `json:"id"`
-	Index     int     `json:"idx"`
-	Name      *string `json:"name"`
-	IsActive  bool    `json:"is_active"`
-	IsFocused bool    `json:"is_focused"`
+func (n *Niri) MoveWindowToWorkspace(index int) error {
+	action := map[string]any{"MoveWindowToWorkspace": map[string]any{
+		"window_id": nil,
+		"reference": map[string]any{"Index": index},
+		"focus":     true,
+	}}
+	if err := n.action(action); err != nil {
+		return fmt.Errorf("error moving window to workspace %d: %w", index, err)
+	}
+
+	return nil
 }
 
-func ListWorkspaces() ([]WorkspaceDescription, error) {
-	var stdout, stderr bytes.Buffer
-	cmd := exec.Command("niri", "msg", "--json", "workspaces")
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// Niri replies to each request with a single line containing either
+// {"Ok": ...} or {"Err": "..."}
+type reply struct {
+	Ok  json.RawMessage `json:"Ok"`
+	Err *string         `json:"Err"`
+}
 
-	if err := cmd.Run(); err != nil {
-		if stdout.Len() > 0 {
-			logger.Log("niri workspaces stdout: %s\n", stdout.String())
-		}
-		if stderr.Len() > 0 {
-			logger.Log("niri workspaces stdout: %s\n", stderr.String())
-		}
-		return nil, fmt.Errorf("error getting workspaces from Niri: %w", err)
+// Send a request on a new connection, and decode the Ok value of the reply
+// into v. If v is nil, the Ok value is ignored.
+func (n *Niri) request(req any, v any) error {
+	conn, err := net.DialTimeout("unix", n.socket, requestTimeout)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", n.socket, err)
 	}
+	defer conn.Close()
 
-	listBytes := stdout.Bytes()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
 
-	workspaces := make([]WorkspaceDescription, 0)
-	if err := json.Unmarshal(listBytes, &workspaces); err != nil {
-		logger.Log("niri window list JSON output:\n")
-		logger.Log(string(listBytes))
-		return nil, fmt.Errorf("error getting windows from Niri: error parsing JSON: %w", err)
-	}
-	return workspaces, nil
+	return roundTrip(conn, bufio.NewReader(conn), req, v)
 }
 
-func historyFromServer() ([]uint64, error) {
-	url := fmt.Sprintf("http://127.0.0.1:%s/api/v1/history", server.Port)
-	resp, err := http.Get(url)
+func (n *Niri) action(action any) error {
+	return n.request(map[string]any{"Action": action}, nil)
+}
+
+func roundTrip(conn net.Conn, r *bufio.Reader, req any, v any) error {
+	buf, err := json.Marshal(req)
 	if err != nil {
-		return nil, fmt.Errorf("error reading from /api/v1/history: %w", err)
+		return fmt.Errorf("error encoding request: %w", err)
 	}
 
-	defer resp.Body.Close()
+	if _, err := conn.Write(append(buf, '\n')); err != nil {
+		return fmt.Errorf("error sending request to Niri: %w", err)
+	}
+
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("error reading reply from Niri: %w", err)
+	}
+
+	var rep reply
+	if err := json.Unmarshal(line, &rep); err != nil {
+		logger.Log("niri reply:\n")
+		logger.Log("%s\n", string(line))
+		return fmt.Errorf("error parsing reply from Niri: %w", err)
+	}
 
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("error reading from /api/v1/history: invalid status code %d, response body: %s", resp.StatusCode, string(body))
+	if rep.Err != nil {
+		return fmt.Errorf("niri returned an error: %s", *rep.Err)
 	}
 
-	history := []uint64{}
-	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
-		return nil, fmt.Errorf("error parsing history JSON: %w", err)
+	if v == nil {
+		return nil
 	}
 
-	return history, nil
+	if err := json.Unmarshal(rep.Ok, v); err != nil {
+		logger.Log("niri reply:\n")
+		logger.Log("%s\n", string(line))
+		return fmt.Errorf("error parsing reply from Niri: %w", err)
+	}
+
+	return nil
+}
+
+type event struct {
+	WindowsChanged *struct {
+		Windows []WindowDescription `json:"windows"`
+	} `json:"WindowsChanged"`
+	WindowOpenedOrChanged *struct {
+		Window WindowDescription `json:"window"`
+	} `json:"WindowOpenedOrChanged"`
+	WindowClosed *struct {
+		ID uint64 `json:"id"`
+	} `json:"WindowClosed"`
+	WindowFocusChanged *struct {
+		ID *uint64 `json:"id"`
+	} `json:"WindowFocusChanged"`
+	WindowUrgencyChanged *struct {
+		ID     uint64 `json:"id"`
+		Urgent bool   `json:"urgent"`
+	} `json:"WindowUrgencyChanged"`
+	WorkspacesChanged *struct {
+		Workspaces []WorkspaceDescription `json:"workspaces"`
+	} `json:"WorkspacesChanged"`
+	WorkspaceActivated *struct {
+		ID      uint64 `json:"id"`
+		Focused bool   `json:"focused"`
+	} `json:"WorkspaceActivated"`
 }
 
-// Sort a list of window descriptions in place, with the most recent windows appearing first
-//
-// windows: A list of windows
-// history: A list of window IDs, ordered so that the most recent windows are at the end
-func sortWindowsByHistory(windows []WindowDescription, history []uint64) {
-	// Create a map from window ID to its position in history
-	historyPos := make(map[uint64]int)
-	for i, id := range history {
-		historyPos[id] = i
+type eventStream struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Open a long-lived connection and ask Niri to stream events on it
+func (n *Niri) EventStream() (compositor.EventStream, error) {
+	conn, err := net.Dial("unix", n.socket)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", n.socket, err)
+	}
+
+	reader := bufio.NewReader(conn)
+
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+	if err := roundTrip(conn, reader, "EventStream", nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error starting Niri event stream: %w", err)
 	}
+	conn.SetDeadline(time.Time{})
 
-	// Sort windows: most recently focused first
-	sort.SliceStable(windows, func(i, j int) bool {
-		idI := uint64(windows[i].ID)
-		idJ := uint64(windows[j].ID)
+	return &eventStream{conn: conn, reader: reader}, nil
+}
 
-		posI, inHistoryI := historyPos[idI]
-		posJ, inHistoryJ := historyPos[idJ]
+func (s *eventStream) Next() (compositor.Event, error) {
+	for {
+		line, err := s.reader.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return compositor.Event{}, io.EOF
+			}
+			return compositor.Event{}, fmt.Errorf("error reading from Niri event stream: %w", err)
+		}
 
-		// If both are in history, sort by position (higher = more recent = comes first)
-		if inHistoryI && inHistoryJ {
-			return posI > posJ
+		if e, ok := parseEvent(line); ok {
+			return e, nil
 		}
+	}
+}
 
-		// If only one is in history, it comes first
-		if inHistoryI {
-			return true
+func (s *eventStream) Close() error {
+	return s.conn.Close()
+}
+
+// Convert a line from Niri's event stream into a compositor event. Returns
+// false for events launchit doesn't use, or lines that can't be parsed.
+func parseEvent(line []byte) (compositor.Event, bool) {
+	var e event
+	if err := json.Unmarshal(line, &e); err != nil {
+		return compositor.Event{}, false
+	}
+
+	switch {
+	case e.WindowsChanged != nil:
+		windows := make([]compositor.Window, 0, len(e.WindowsChanged.Windows))
+		for _, w := range e.WindowsChanged.Windows {
+			windows = append(windows, w.toWindow())
 		}
-		if inHistoryJ {
-			return false
+		return compositor.Event{WindowsChanged: &compositor.WindowsChanged{Windows: windows}}, true
+	case e.WindowOpenedOrChanged != nil:
+		return compositor.Event{WindowOpenedOrChanged: &compositor.WindowOpenedOrChanged{
+			Window: e.WindowOpenedOrChanged.Window.toWindow(),
+		}}, true
+	case e.WindowClosed != nil:
+		return compositor.Event{WindowClosed: &compositor.WindowClosed{ID: e.WindowClosed.ID}}, true
+	case e.WindowFocusChanged != nil:
+		return compositor.Event{WindowFocusChanged: &compositor.WindowFocusChanged{ID: e.WindowFocusChanged.ID}}, true
+	case e.WindowUrgencyChanged != nil:
+		return compositor.Event{WindowUrgencyChanged: &compositor.WindowUrgencyChanged{
+			ID:     e.WindowUrgencyChanged.ID,
+			Urgent: e.WindowUrgencyChanged.Urgent,
+		}}, true
+	case e.WorkspacesChanged != nil:
+		workspaces := make([]compositor.Workspace, 0, len(e.WorkspacesChanged.Workspaces))
+		for _, w := range e.WorkspacesChanged.Workspaces {
+			workspaces = append(workspaces, w.toWorkspace())
 		}
+		return compositor.Event{WorkspacesChanged: &compositor.WorkspacesChanged{Workspaces: workspaces}}, true
+	case e.WorkspaceActivated != nil:
+		return compositor.Event{WorkspaceActivated: &compositor.WorkspaceActivated{
+			ID:      int64(e.WorkspaceActivated.ID),
+			Focused: e.WorkspaceActivated.Focused,
+		}}, true
+	}
 
-		// If neither is in history, maintain original order (stable sort handles this)
-		return false
-	})
+	return compositor.Event{}, false
+}
+
+func (w WindowDescription) toWindow() compositor.Window {
+	var workspaceID int64
+	if w.WorkspaceID != nil {
+		workspaceID = int64(*w.WorkspaceID)
+	}
+
+	return compositor.Window{
+		ID:          w.ID,
+		Title:       w.Title,
+		AppID:       w.AppID,
+		WorkspaceID: workspaceID,
+		IsFocused:   w.IsFocused,
+		IsUrgent:    w.IsUrgent,
+	}
+}
 
+func (w WorkspaceDescription) toWorkspace() compositor.Workspace {
+	var name, output string
+	if w.Name != nil {
+		name = *w.Name
+	}
+	if w.Output != nil {
+		output = *w.Output
+	}
+
+	return compositor.Workspace{
+		ID:        int64(w.ID),
+		Index:     w.Index,
+		Name:      name,
+		Output:    output,
+		IsActive:  w.IsActive,
+		IsFocused: w.IsFocused,
+	}
 }
@@ -0,0 +1,256 @@
+package niri
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jplein/launchit/pkg/common/compositor"
+)
+
+// A stand-in for Niri's IPC socket: it answers each request with the canned
+// reply for it, and hands the test the connections that ask for the event
+// stream
+type fakeNiri struct {
+	// Replies by request, both as single lines of JSON
+	replies map[string]string
+
+	// The requests received
+	requests []string
+
+	// Connections that asked for the event stream
+	events chan net.Conn
+
+	mu sync.Mutex
+}
+
+func startFake(t *testing.T) (*fakeNiri, string) {
+	t.Helper()
+
+	socket := path.Join(t.TempDir(), "niri.sock")
+	f := &fakeNiri{
+		replies: map[string]string{`"EventStream"`: `{"Ok":"Handled"}`},
+		events:  make(chan net.Conn, 1),
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(t, conn)
+		}
+	}()
+
+	return f, socket
+}
+
+// Answer requests on a connection until it is closed, or until it asks for the
+// event stream, after which the test writes to it
+func (f *fakeNiri) serve(t *testing.T, conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return
+		}
+		request := strings.TrimSuffix(line, "\n")
+
+		f.mu.Lock()
+		f.requests = append(f.requests, request)
+		reply, ok := f.replies[request]
+		f.mu.Unlock()
+
+		if !ok {
+			t.Errorf("unexpected request %s", request)
+			reply = `{"Err":"unexpected request"}`
+		}
+
+		io.WriteString(conn, reply+"\n")
+
+		if request == `"EventStream"` {
+			t.Cleanup(func() { conn.Close() })
+			f.events <- conn
+			return
+		}
+	}
+}
+
+// Change the canned replies, or look at the requests received, holding the lock
+func (f *fakeNiri) update(change func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	change()
+}
+
+func TestRequests(t *testing.T) {
+	f, socket := startFake(t)
+	f.update(func() {
+		f.replies[`"Windows"`] = `{"Ok":{"Windows":[` +
+			`{"id":1,"title":"~","app_id":"foot","workspace_id":3,"is_focused":true,"is_urgent":false},` +
+			`{"id":2,"title":"Picture-in-Picture","app_id":"firefox","workspace_id":null,"is_focused":false,"is_urgent":true}]}}`
+		f.replies[`{"Action":{"FocusWindow":{"id":1}}}`] = `{"Ok":"Handled"}`
+		f.replies[`{"Action":{"FocusWindow":{"id":9}}}`] = `{"Err":"no window with id 9"}`
+	})
+
+	n := NewWithSocket(socket)
+
+	windows, err := n.ListWindows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []compositor.Window{
+		{ID: 1, Title: "~", AppID: "foot", WorkspaceID: 3, IsFocused: true},
+		{ID: 2, Title: "Picture-in-Picture", AppID: "firefox", IsUrgent: true},
+	}
+	if !reflect.DeepEqual(windows, want) {
+		t.Errorf("windows %+v, want %+v", windows, want)
+	}
+
+	if err := n.FocusWindow(1); err != nil {
+		t.Errorf("focusing window 1 returned error: %v", err)
+	}
+
+	err = n.FocusWindow(9)
+	if err == nil || !strings.Contains(err.Error(), "no window with id 9") {
+		t.Errorf("focusing window 9 returned %v, want the error from Niri", err)
+	}
+
+	// A reply that isn't JSON
+	f.update(func() { f.replies[`"Workspaces"`] = `Workspaces` })
+	if _, err := n.ListWorkspaces(); err == nil {
+		t.Error("listing workspaces with an invalid reply returned no error")
+	}
+
+	// Each request is a line of JSON
+	requests := []string{
+		`"Windows"`,
+		`{"Action":{"FocusWindow":{"id":1}}}`,
+		`{"Action":{"FocusWindow":{"id":9}}}`,
+		`"Workspaces"`,
+	}
+	f.update(func() {
+		if !reflect.DeepEqual(f.requests, requests) {
+			t.Errorf("sent requests %q, want %q", f.requests, requests)
+		}
+	})
+}
+
+func TestEventStream(t *testing.T) {
+	f, socket := startFake(t)
+
+	stream, err := NewWithSocket(socket).EventStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	events := <-f.events
+
+	// Events launchit doesn't use, and lines that can't be parsed, are skipped
+	io.WriteString(events, `{"KeyboardLayoutSwitched":{"idx":1}}`+"\n")
+	io.WriteString(events, "not JSON\n")
+	io.WriteString(events, `{"WindowClosed":{"id":4}}`+"\n")
+	events.Close()
+
+	got, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (compositor.Event{WindowClosed: &compositor.WindowClosed{ID: 4}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("first event %+v, want %+v", got, want)
+	}
+
+	if _, err := stream.Next(); err != io.EOF {
+		t.Errorf("reading after Niri closed the stream returned %v, want io.EOF", err)
+	}
+}
+
+func TestEventStreamRefused(t *testing.T) {
+	f, socket := startFake(t)
+	f.update(func() { f.replies[`"EventStream"`] = `{"Err":"too many clients"}` })
+
+	if _, err := NewWithSocket(socket).EventStream(); err == nil || !strings.Contains(err.Error(), "too many clients") {
+		t.Errorf("starting the event stream returned %v, want the error from Niri", err)
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	focused := uint64(5)
+
+	tests := []struct {
+		line string
+		want compositor.Event
+	}{
+		{
+			line: `{"WindowsChanged":{"windows":[{"id":5,"title":"~","app_id":"foot","workspace_id":1,"is_focused":true,"is_urgent":false}]}}`,
+			want: compositor.Event{WindowsChanged: &compositor.WindowsChanged{Windows: []compositor.Window{
+				{ID: 5, Title: "~", AppID: "foot", WorkspaceID: 1, IsFocused: true},
+			}}},
+		},
+		{
+			line: `{"WindowOpenedOrChanged":{"window":{"id":6,"title":"New Tab","app_id":"firefox","workspace_id":2,"is_focused":false,"is_urgent":false}}}`,
+			want: compositor.Event{WindowOpenedOrChanged: &compositor.WindowOpenedOrChanged{
+				Window: compositor.Window{ID: 6, Title: "New Tab", AppID: "firefox", WorkspaceID: 2},
+			}},
+		},
+		{
+			line: `{"WindowClosed":{"id":6}}`,
+			want: compositor.Event{WindowClosed: &compositor.WindowClosed{ID: 6}},
+		},
+		{
+			line: `{"WindowFocusChanged":{"id":5}}`,
+			want: compositor.Event{WindowFocusChanged: &compositor.WindowFocusChanged{ID: &focused}},
+		},
+		{
+			// No window is focused
+			line: `{"WindowFocusChanged":{"id":null}}`,
+			want: compositor.Event{WindowFocusChanged: &compositor.WindowFocusChanged{}},
+		},
+		{
+			line: `{"WindowUrgencyChanged":{"id":5,"urgent":true}}`,
+			want: compositor.Event{WindowUrgencyChanged: &compositor.WindowUrgencyChanged{ID: 5, Urgent: true}},
+		},
+		{
+			line: `{"WorkspacesChanged":{"workspaces":[` +
+				`{"id":1,"idx":1,"name":null,"output":"DP-1","is_active":true,"is_focused":true},` +
+				`{"id":2,"idx":2,"name":"web","output":null,"is_active":false,"is_focused":false}]}}`,
+			want: compositor.Event{WorkspacesChanged: &compositor.WorkspacesChanged{Workspaces: []compositor.Workspace{
+				{ID: 1, Index: 1, Output: "DP-1", IsActive: true, IsFocused: true},
+				{ID: 2, Index: 2, Name: "web"},
+			}}},
+		},
+		{
+			line: `{"WorkspaceActivated":{"id":2,"focused":true}}`,
+			want: compositor.Event{WorkspaceActivated: &compositor.WorkspaceActivated{ID: 2, Focused: true}},
+		},
+	}
+
+	for _, test := range tests {
+		got, ok := parseEvent([]byte(test.line))
+		if !ok {
+			t.Errorf("parseEvent(%s) skipped the event", test.line)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseEvent(%s) = %+v, want %+v", test.line, got, test.want)
+		}
+	}
+
+	for _, line := range []string{`{"OverviewOpenedOrClosed":{"is_open":true}}`, `{"WindowClosed":`, ``} {
+		if got, ok := parseEvent([]byte(line)); ok {
+			t.Errorf("parseEvent(%s) = %+v, want it skipped", line, got)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/compositor"
+	"github.com/jplein/launchit/pkg/common/state/locations"
+)
+
+// Set this environment variable to reach the server at a different address.
+// Values starting with / are socket paths; anything else is a TCP host:port.
+const AddressEnvVar = "LAUNCHIT_SERVER"
+
+// How long to wait for the server before falling back to asking the
+// compositor directly
+const requestTimeout = time.Second
+
+var httpClient = &http.Client{
+	Timeout: requestTimeout,
+	Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			network, addr := Address()
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	},
+}
+
+// Returns the network ("unix" or "tcp") and address of the server for the
+// current session
+func Address() (string, string) {
+	addr := os.Getenv(AddressEnvVar)
+	if addr == "" {
+		return "unix", locations.ServerSocketFilename()
+	}
+
+	if strings.HasPrefix(addr, "/") {
+		return "unix", addr
+	}
+
+	return "tcp", addr
+}
+
+// Returns the server's window focus history, ordered so that the most recent
+// windows are at the end
+func History() ([]uint64, error) {
+	history := []uint64{}
+	if err := Get("/api/v1/history", &history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// Returns the open windows, most recently focused first
+func Windows() ([]compositor.Window, error) {
+	windows := []compositor.Window{}
+	if err := Get("/api/v1/windows", &windows); err != nil {
+		return nil, err
+	}
+
+	return windows, nil
+}
+
+func Workspaces() ([]compositor.Workspace, error) {
+	workspaces := []compositor.Workspace{}
+	if err := Get("/api/v1/workspaces", &workspaces); err != nil {
+		return nil, err
+	}
+
+	return workspaces, nil
+}
+
+// Tell the server that an application was just launched, so that it can learn
+// which windows belong to the application if their app ID doesn't say
+//
+// appID: The desktop file ID of the application, without .desktop
+func RecordLaunch(appID string) error {
+	return Post("/api/v1/launches", Launch{AppID: appID})
+}
+
+// The body of a request to /api/v1/launches
+type Launch struct {
+	AppID string `json:"app-id"`
+}
+
+// Fetch endpoint from the server and decode the JSON response into v
+//
+// endpoint: The path and query string, e.g., "/api/v1/history"
+func Get(endpoint string, v any) error {
+	// The host is ignored; the transport always dials Address()
+	resp, err := httpClient.Get("http://launchit" + endpoint)
+	if err != nil {
+		return fmt.Errorf("error reading from %s: %w", endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error reading from %s: invalid status code %d, response body: %s", endpoint, resp.StatusCode, string(body))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error parsing %s JSON: %w", endpoint, err)
+	}
+
+	return nil
+}
+
+// Send v to endpoint on the server as JSON
+//
+// endpoint: The path, e.g., "/api/v1/launches"
+func Post(endpoint string, v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error sending to %s: %w", endpoint, err)
+	}
+
+	// The host is ignored; the transport always dials Address()
+	resp, err := httpClient.Post("http://launchit"+endpoint, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("error sending to %s: %w", endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error sending to %s: invalid status code %d, response body: %s", endpoint, resp.StatusCode, string(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/desktop"
+	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/common/source"
+	"github.com/jplein/launchit/pkg/common/state/locations"
+)
+
+// How long to wait after the last change before rebuilding, so that a burst
+// of compositor events causes a single rebuild
+const rebuildDelay = 300 * time.Millisecond
+
+// EntryCache keeps the entries from every source, so that `launchit write`
+// doesn't have to scan .desktop files and query the compositor each time it
+// runs
+type EntryCache struct {
+	// Entries by source name
+	entries map[string][]source.Entry
+
+	// Source names, in the order of the default source set
+	order []string
+
+	// The state of the files the entries were built from, at the time they
+	// were built
+	fingerprint string
+
+	// Incremented on every invalidation. The entries are valid if they were
+	// built at the current generation.
+	generation atomic.Uint64
+	builtAt    uint64
+	built      bool
+
+	trigger chan struct{}
+	mu      sync.Mutex
+}
+
+func NewEntryCache() *EntryCache {
+	return &EntryCache{trigger: make(chan struct{}, 1)}
+}
+
+// Start rebuilding the cache in the background whenever it is invalidated
+func (c *EntryCache) Run() {
+	go func() {
+		for range c.trigger {
+			timer := time.NewTimer(rebuildDelay)
+		wait:
+			for {
+				select {
+				case <-c.trigger:
+					timer.Reset(rebuildDelay)
+				case <-timer.C:
+					break wait
+				}
+			}
+
+			c.mu.Lock()
+			if !c.isValid() {
+				c.rebuild()
+			}
+			c.mu.Unlock()
+		}
+	}()
+}
+
+// Mark the cache as stale and schedule a rebuild. Safe to call while holding
+// other locks; it never blocks.
+func (c *EntryCache) Invalidate() {
+	c.generation.Add(1)
+
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Returns the cached entries, rebuilding them first if they are stale
+//
+// sourceName: If not empty, only return entries from the source with this name
+func (c *EntryCache) Entries(sourceName string) ([]source.Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.isValid() || c.fingerprint != fingerprint() {
+		c.rebuild()
+	}
+
+	if sourceName != "" {
+		sourceEntries, ok := c.entries[sourceName]
+		if !ok {
+			return nil, fmt.Errorf("no source with name %s", sourceName)
+		}
+
+		return append([]source.Entry{}, sourceEntries...), nil
+	}
+
+	entries := make([]source.Entry, 0)
+	for _, name := range c.order {
+		entries = append(entries, c.entries[name]...)
+	}
+
+	return entries, nil
+}
+
+// Must be called with c.mu held
+func (c *EntryCache) isValid() bool {
+	return c.built && c.builtAt == c.generation.Load()
+}
+
+// Must be called with c.mu held
+func (c *EntryCache) rebuild() {
+	start := time.Now()
+	generation := c.generation.Load()
+
+	// Take the fingerprint first, so that a change made while the sources are
+	// being read causes another rebuild
+	c.fingerprint = fingerprint()
+
+	sources, err := source.DefaultSourceSet()
+	if err != nil {
+		logger.Log("error rebuilding entry cache: %v\n", err)
+		return
+	}
+
+	c.entries = make(map[string][]source.Entry)
+	c.order = make([]string, 0, len(sources.Sources))
+
+	for _, s := range sources.Sources {
+		entries, err := s.List()
+		if err != nil {
+			logger.Log("error rebuilding entry cache for source %s: %v\n", s.Name(), err)
+			entries = []source.Entry{}
+		}
+
+		c.entries[s.Name()] = entries
+		c.order = append(c.order, s.Name())
+	}
+
+	c.builtAt = generation
+	c.built = true
+	logger.Log("rebuilt entry cache in %v\n", time.Since(start).Round(time.Millisecond))
+}
+
+// Summarize the state of the application directories and the modification
+// times of the configuration files, so that edits to them can be detected
+// cheaply
+func fingerprint() string {
+	var sb strings.Builder
+
+	apps, err := desktop.Fingerprint()
+	if err != nil {
+		logger.Log("error getting application directories: %v\n", err)
+	}
+	sb.WriteString(apps)
+
+	configDir, err := locations.ConfigDirectory()
+	if err != nil {
+		logger.Log("error getting config directory: %v\n", err)
+		return sb.String()
+	}
+
+	configEntries, err := os.ReadDir(configDir)
+	if err != nil {
+		return sb.String()
+	}
+
+	for _, e := range configEntries {
+		p := path.Join(configDir, e.Name())
+
+		info, err := os.Stat(p)
+		if err != nil {
+			fmt.Fprintf(&sb, "%s:-;", p)
+			continue
+		}
+
+		fmt.Fprintf(&sb, "%s:%d;", p, info.ModTime().UnixNano())
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Set up an application directory reached through a chain of symlinks, the
+// way a Nix profile is: ~/.nix-profile links to a profile, which links to a
+// generation, which links to an environment in the store holding an
+// application with the given name. Returns a function that switches the
+// profile to a new generation holding an application with another name,
+// without touching the old one.
+func useSymlinkedApplications(t *testing.T, name string) func(name string) {
+	t.Helper()
+
+	tmp := t.TempDir()
+	home := filepath.Join(tmp, "home")
+	profiles := filepath.Join(tmp, "profiles")
+	for _, dir := range []string{home, profiles} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// All generations get the same modification time, as the files in the Nix
+	// store do
+	mtime := time.Unix(1, 0)
+
+	generation := 0
+	addGeneration := func(name string) string {
+		generation++
+
+		env := filepath.Join(tmp, "store", name+"-env")
+		apps := filepath.Join(env, "share", "applications")
+		if err := os.MkdirAll(apps, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		contents := "[Desktop Entry]\nType=Application\nName=" + name + "\nExec=true\n"
+		if err := os.WriteFile(filepath.Join(apps, name+".desktop"), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(apps, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		link := filepath.Join(profiles, fmt.Sprintf("profile-%d-link", generation))
+		if err := os.Symlink(env, link); err != nil {
+			t.Fatal(err)
+		}
+
+		return filepath.Base(link)
+	}
+
+	if err := os.Symlink(addGeneration(name), filepath.Join(profiles, "profile")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(profiles, "profile"), filepath.Join(home, ".nix-profile")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(tmp, "data"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmp, "config"))
+	t.Setenv("XDG_DATA_DIRS", filepath.Join(home, ".nix-profile", "share"))
+
+	// Switch the profile the way nix-env does, by renaming a new link over it
+	return func(name string) {
+		tmpLink := filepath.Join(profiles, "profile.tmp")
+		if err := os.Symlink(addGeneration(name), tmpLink); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmpLink, filepath.Join(profiles, "profile")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns the names of the application entries
+func applicationNames(t *testing.T, c *EntryCache) []string {
+	t.Helper()
+
+	entries, err := c.Entries("applications")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Description)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// Switching a symlinked application directory changes neither its path nor
+// its modification time, only where it leads
+func TestEntriesFollowSymlinkSwitch(t *testing.T) {
+	switchProfile := useSymlinkedApplications(t, "Foo")
+
+	c := NewEntryCache()
+	if names := applicationNames(t, c); !slices.Equal(names, []string{"Foo"}) {
+		t.Fatalf("applications %v, want [Foo]", names)
+	}
+
+	// The first build writes the default config files, which changes the
+	// fingerprint, so build again to start from a settled cache
+	applicationNames(t, c)
+
+	switchProfile("Bar")
+
+	if names := applicationNames(t, c); !slices.Equal(names, []string{"Bar"}) {
+		t.Errorf("applications after switching the profile %v, want [Bar]", names)
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/common/state"
+	"github.com/jplein/launchit/pkg/common/state/locations"
+)
+
+const (
+	// Maximum number of windows to remember across restarts
+	maxSavedWindows = 200
+
+	// How long to wait after the history changes before saving it, so that a
+	// burst of focus changes causes a single write
+	saveDelay = 2 * time.Second
+)
+
+// A window in the saved focus history. Window IDs don't survive a compositor
+// restart, so windows are identified by attributes that usually do.
+type windowRecord struct {
+	AppID     string `json:"app_id"`
+	Title     string `json:"title"`
+	Workspace string `json:"workspace"`
+}
+
+// Read the saved focus history, oldest first
+func loadWindowHistory() ([]windowRecord, error) {
+	file, err := locations.WindowHistoryFilename()
+	if err != nil {
+		return nil, fmt.Errorf("error loading window history: %w", err)
+	}
+
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []windowRecord{}, nil
+		}
+		return nil, fmt.Errorf("error loading window history: error reading from %s: %w", file, err)
+	}
+
+	records := []windowRecord{}
+	if err := json.Unmarshal(buf, &records); err != nil {
+		return nil, fmt.Errorf("error loading window history: error parsing %s as JSON: %w", file, err)
+	}
+
+	return records, nil
+}
+
+func saveWindowHistory(records []windowRecord) error {
+	file, err := locations.WindowHistoryFilename()
+	if err != nil {
+		return fmt.Errorf("error saving window history: %w", err)
+	}
+
+	buf, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("error saving window history: error marshaling to JSON: %w", err)
+	}
+
+	if err := state.WriteFileAtomic(file, buf, 0o644); err != nil {
+		return fmt.Errorf("error saving window history: %w", err)
+	}
+
+	return nil
+}
+
+// Load the saved history, to be matched against the live windows once the
+// compositor sends the window list. Must be called before Listen.
+func (n *EventListener) LoadHistory() {
+	records, err := loadWindowHistory()
+	if err != nil {
+		logger.Log("%v\n", err)
+		return
+	}
+
+	n.saved = records
+}
+
+// Schedule the history to be written to disk. Must be called with n.mu held.
+func (n *EventListener) scheduleSave() {
+	if n.saveTimer != nil {
+		n.saveTimer.Reset(saveDelay)
+		return
+	}
+
+	n.saveTimer = time.AfterFunc(saveDelay, n.save)
+}
+
+func (n *EventListener) save() {
+	n.mu.Lock()
+	records := n.records()
+
+	// Don't overwrite the saved history before the window list has arrived,
+	// and keep it to match against the windows once it does
+	if len(records) == 0 {
+		n.mu.Unlock()
+		return
+	}
+
+	n.saved = records
+	n.mu.Unlock()
+
+	if err := saveWindowHistory(records); err != nil {
+		logger.Log("%v\n", err)
+	}
+}
+
+// Describe the current history in terms of stable attributes, oldest first.
+// Must be called with n.mu held.
+func (n *EventListener) records() []windowRecord {
+	records := make([]windowRecord, 0, len(n.windowHistory))
+	for _, id := range n.windowHistory {
+		w, ok := n.model.Window(id)
+		if !ok {
+			continue
+		}
+
+		records = append(records, windowRecord{
+			AppID:     w.AppID,
+			Title:     w.Title,
+			Workspace: n.model.WorkspaceKey(w.WorkspaceID),
+		})
+	}
+
+	if len(records) > maxSavedWindows {
+		records = records[len(records)-maxSavedWindows:]
+	}
+
+	return records
+}
+
+// Drop windows that no longer exist from the history. If that leaves the
+// history empty (e.g., the server or the compositor just started), rebuild it
+// by matching the saved history against the live windows. Must be called with
+// n.mu held.
+func (n *EventListener) reconcileHistory() {
+	history := make([]uint64, 0, len(n.windowHistory))
+	for _, id := range n.windowHistory {
+		if _, ok := n.model.Window(id); ok {
+			history = append(history, id)
+		}
+	}
+	n.windowHistory = history
+
+	if len(n.windowHistory) > 0 || len(n.saved) == 0 {
+		return
+	}
+
+	windows, _ := n.model.Windows(nil)
+	matched := make(map[uint64]bool)
+
+	// Records are oldest first, so appending the match for each one leaves the
+	// most recent window at the end, as addWindowToHistory does
+	for _, r := range n.saved {
+		bestScore := 0
+		var bestID uint64
+
+		for _, w := range windows {
+			if matched[w.ID] || w.AppID != r.AppID {
+				continue
+			}
+
+			// Any window of the same application is a candidate; prefer one
+			// with the same title, then one on the same workspace
+			score := 1
+			if w.Title == r.Title {
+				score += 2
+			}
+			if r.Workspace != "" && n.model.WorkspaceKey(w.WorkspaceID) == r.Workspace {
+				score += 1
+			}
+
+			if score > bestScore {
+				bestScore = score
+				bestID = w.ID
+			}
+		}
+
+		if bestScore > 0 {
+			matched[bestID] = true
+			n.windowHistory = append(n.windowHistory, bestID)
+		}
+	}
+
+	logger.Log("restored focus history for %d of %d saved windows\n", len(n.windowHistory), len(n.saved))
+}
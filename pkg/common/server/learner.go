@@ -0,0 +1,135 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/compositor"
+	"github.com/jplein/launchit/pkg/common/desktop"
+	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/overrides"
+)
+
+// How long after a launch a new window is taken to be the launched
+// application's
+const learnWindow = 10 * time.Second
+
+// An application launched by `launchit read` whose window hasn't been seen yet
+type pendingLaunch struct {
+	appID string
+	at    time.Time
+}
+
+// Learns which applications windows belong to when their app ID doesn't say:
+// if a window opens shortly after an application is launched, and its app ID
+// matches no application, it is taken to be that application's
+type OverrideLearner struct {
+	pending []pendingLaunch
+	onLearn func()
+
+	mu sync.Mutex
+}
+
+func NewOverrideLearner() *OverrideLearner {
+	return &OverrideLearner{pending: make([]pendingLaunch, 0)}
+}
+
+// Call f after an override is learned. Must be called before the learner is
+// used.
+func (l *OverrideLearner) OnLearn(f func()) {
+	l.onLearn = f
+}
+
+// Record that an application was launched
+//
+// appID: The desktop file ID of the application, without .desktop
+func (l *OverrideLearner) Launched(appID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expire()
+	l.pending = append(l.pending, pendingLaunch{appID: appID, at: time.Now()})
+}
+
+// Drop launches that are too old to match a window. Must be called with mu
+// held.
+func (l *OverrideLearner) expire() {
+	kept := l.pending[:0]
+	for _, p := range l.pending {
+		if time.Since(p.at) <= learnWindow {
+			kept = append(kept, p)
+		}
+	}
+	l.pending = kept
+}
+
+// Handle a window that opened, or that got its app ID after opening. Reads the
+// desktop database and the overrides, so shouldn't be called with the event
+// listener's lock held.
+func (l *OverrideLearner) WindowOpened(window compositor.Window) {
+	if window.AppID == "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expire()
+	if len(l.pending) == 0 {
+		return
+	}
+
+	db, err := desktop.Load()
+	if err != nil {
+		logger.Log("error loading applications to match window %d: %v\n", window.ID, err)
+		return
+	}
+
+	// The window matches an application already, so there is nothing to
+	// learn. If it was one of the launched applications, that launch is done.
+	if app := db.ForWindow(window.AppID); app != nil {
+		l.remove(app.ID)
+		return
+	}
+
+	if or, err := overrides.ByWindowAppID(window.AppID); err != nil || or != nil {
+		if or != nil {
+			l.remove(or.AppID)
+		}
+		return
+	}
+
+	// With several launches waiting, there's no telling which this window is
+	if len(l.pending) > 1 {
+		logger.Log("window %d with app ID %s opened after %d launches, not learning which application it belongs to\n", window.ID, window.AppID, len(l.pending))
+		return
+	}
+
+	launch := l.pending[0]
+	l.pending = l.pending[:0]
+
+	learned, err := overrides.Learn(launch.appID, window.AppID)
+	if err != nil {
+		logger.Log("error learning override for window app ID %s: %v\n", window.AppID, err)
+		return
+	}
+
+	if learned {
+		logger.Log("learned that windows with app ID %s belong to %s\n", window.AppID, launch.appID)
+
+		if l.onLearn != nil {
+			l.onLearn()
+		}
+	}
+}
+
+// Remove the pending launches of an application. Must be called with mu held.
+func (l *OverrideLearner) remove(appID string) {
+	kept := l.pending[:0]
+	for _, p := range l.pending {
+		if p.appID != appID {
+			kept = append(kept, p)
+		}
+	}
+	l.pending = kept
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/jplein/launchit/pkg/common/compositor"
+)
+
+// Model is an in-memory copy of the compositor's windows and workspaces, kept
+// up to date from its event stream. It is not safe for concurrent use; callers
+// must hold EventListener.mu.
+type Model struct {
+	windows    map[uint64]compositor.Window
+	workspaces []compositor.Workspace
+
+	// Whether the full window and workspace lists have been received. Until
+	// then, the model can't answer queries.
+	haveWindows    bool
+	haveWorkspaces bool
+}
+
+func NewModel() *Model {
+	return &Model{windows: make(map[uint64]compositor.Window)}
+}
+
+// Update the model with an event from the compositor
+func (m *Model) Apply(event compositor.Event) {
+	switch {
+	case event.WindowsChanged != nil:
+		m.windows = make(map[uint64]compositor.Window, len(event.WindowsChanged.Windows))
+		for _, w := range event.WindowsChanged.Windows {
+			m.windows[w.ID] = w
+		}
+		m.haveWindows = true
+	case event.WindowOpenedOrChanged != nil:
+		w := event.WindowOpenedOrChanged.Window
+		if w.IsFocused {
+			m.setFocusedWindow(&w.ID)
+		}
+		m.windows[w.ID] = w
+	case event.WindowClosed != nil:
+		delete(m.windows, event.WindowClosed.ID)
+	case event.WindowFocusChanged != nil:
+		m.setFocusedWindow(event.WindowFocusChanged.ID)
+	case event.WindowUrgencyChanged != nil:
+		if w, ok := m.windows[event.WindowUrgencyChanged.ID]; ok {
+			w.IsUrgent = event.WindowUrgencyChanged.Urgent
+			m.windows[w.ID] = w
+		}
+	case event.WorkspacesChanged != nil:
+		m.workspaces = slices.Clone(event.WorkspacesChanged.Workspaces)
+		m.haveWorkspaces = true
+	case event.WorkspaceActivated != nil:
+		m.activateWorkspace(event.WorkspaceActivated.ID, event.WorkspaceActivated.Focused)
+	}
+}
+
+func (m *Model) setFocusedWindow(id *uint64) {
+	for windowID, w := range m.windows {
+		focused := id != nil && windowID == *id
+		if w.IsFocused == focused {
+			continue
+		}
+
+		w.IsFocused = focused
+
+		// Compositors don't all report urgency being cleared, but focusing a
+		// window always does
+		if focused {
+			w.IsUrgent = false
+		}
+
+		m.windows[windowID] = w
+	}
+}
+
+// Mark the workspace as active on its output, deactivating the others on the
+// same output. If focused is true, it also becomes the only focused workspace.
+func (m *Model) activateWorkspace(id int64, focused bool) {
+	i := slices.IndexFunc(m.workspaces, func(w compositor.Workspace) bool { return w.ID == id })
+	if i == -1 {
+		return
+	}
+
+	output := m.workspaces[i].Output
+	for j := range m.workspaces {
+		ws := &m.workspaces[j]
+		if ws.Output == output {
+			ws.IsActive = ws.ID == id
+		}
+		if focused {
+			ws.IsFocused = ws.ID == id
+		}
+	}
+}
+
+// Returns the windows, ordered by the given focus history, or false if the
+// window list hasn't been received yet
+//
+// history: A list of window IDs, ordered so that the most recent windows are
+// at the end
+func (m *Model) Windows(history []uint64) ([]compositor.Window, bool) {
+	if !m.haveWindows {
+		return nil, false
+	}
+
+	windows := make([]compositor.Window, 0, len(m.windows))
+	seen := make(map[uint64]bool, len(m.windows))
+
+	for i := len(history) - 1; i >= 0; i-- {
+		if w, ok := m.windows[history[i]]; ok && !seen[w.ID] {
+			windows = append(windows, w)
+			seen[w.ID] = true
+		}
+	}
+
+	rest := make([]compositor.Window, 0)
+	for id, w := range m.windows {
+		if !seen[id] {
+			rest = append(rest, w)
+		}
+	}
+	slices.SortFunc(rest, func(a, b compositor.Window) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return append(windows, rest...), true
+}
+
+// Returns the window with the given ID, or false if there is no such window
+func (m *Model) Window(id uint64) (compositor.Window, bool) {
+	w, ok := m.windows[id]
+	return w, ok
+}
+
+// Returns the key (see compositor.Workspace.Key) of the workspace with the
+// given ID, or an empty string if the workspace isn't known.
+func (m *Model) WorkspaceKey(id int64) string {
+	for _, ws := range m.workspaces {
+		if ws.ID == id {
+			return ws.Key()
+		}
+	}
+
+	return ""
+}
+
+// Returns the workspaces, or false if the workspace list hasn't been received
+// yet
+func (m *Model) Workspaces() ([]compositor.Workspace, bool) {
+	if !m.haveWorkspaces {
+		return nil, false
+	}
+
+	return slices.Clone(m.workspaces), true
+}
@@ -1,18 +1,25 @@
 package server
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
-	"os/exec"
+	"os"
+	"path"
 	"sync"
 	"time"
 
+	"github.com/jplein/launchit/pkg/common/compositor"
+	"github.com/jplein/launchit/pkg/common/launcher"
 	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/common/server/client"
+	"github.com/jplein/launchit/pkg/common/state/locations"
+	"github.com/jplein/launchit/pkg/common/wm"
 )
 
-const Port = "17324"
-
 const (
 	// Maximum number of requests allowed per minute for each handler
 	maxRequestsPerMinute = 60
@@ -61,81 +68,92 @@ func (ls *LoadShedder) Allow() bool {
 	return true
 }
 
-type NiriEvent struct {
-	WindowFocusChanged *struct {
-		ID uint64 `json:"id"`
-	} `json:"WindowFocusChanged"`
-	WindowClosed *struct {
-		ID uint64 `json:"id"`
-	} `json:"WindowClosed"`
-	WindowOpenedOrChanged *struct {
-		Window struct {
-			ID uint64 `json:"id"`
-		} `json:"window"`
-	} `json:"WindowOpenedOrChanged"`
-}
-
-type NiriEventListener struct {
+// EventListener follows the compositor's event stream, keeping track of the
+// order in which windows were focused and a model of windows and workspaces
+type EventListener struct {
+	compositor    compositor.Compositor
 	lastEvent     string
 	windowHistory []uint64
-	mu            sync.RWMutex
+	model         *Model
+	onChange      func()
+
+	// Called with windows that opened, or got an app ID after opening
+	onWindowOpened func(compositor.Window)
+
+	// The focus history as last saved to disk, oldest first
+	saved     []windowRecord
+	saveTimer *time.Timer
+
+	mu sync.RWMutex
+}
+
+func NewEventListener(c compositor.Compositor) *EventListener {
+	return &EventListener{compositor: c, model: NewModel()}
 }
 
-func (n *NiriEventListener) Listen() error {
+func (n *EventListener) Listen() error {
 	go func() {
 		const (
 			initialBackoff = time.Second
 			maxBackoff     = 64 * time.Second
-			cooldownPeriod = 5 * time.Minute // Reset backoff if process runs this long
+			cooldownPeriod = 5 * time.Minute // Reset backoff if the stream stays up this long
 		)
 
 		backoff := initialBackoff
+		name := n.compositor.Name()
 
 		for {
 			startTime := time.Now()
 
-			cmd := exec.Command("niri", "msg", "-j", "event-stream")
-			stdout, err := cmd.StdoutPipe()
+			stream, err := n.compositor.EventStream()
 			if err != nil {
-				logger.Log("error creating stdout pipe for niri event-stream: %v, retrying in %v\n", err, backoff)
+				logger.Log("error opening %s event stream: %v, retrying in %v\n", name, err, backoff)
 				time.Sleep(backoff)
 				backoff = min(backoff*2, maxBackoff)
 				continue
 			}
 
-			if err := cmd.Start(); err != nil {
-				logger.Log("error starting niri event-stream: %v, retrying in %v\n", err, backoff)
-				time.Sleep(backoff)
-				backoff = min(backoff*2, maxBackoff)
-				continue
-			}
+			logger.Log("%s event stream started\n", name)
 
-			logger.Log("niri event-stream process started\n")
+			for {
+				event, err := stream.Next()
+				if err != nil {
+					if !errors.Is(err, io.EOF) {
+						logger.Log("error reading from %s event stream: %v\n", name, err)
+					}
+					break
+				}
 
-			scanner := bufio.NewScanner(stdout)
-			for scanner.Scan() {
-				line := scanner.Text()
 				n.mu.Lock()
-				n.lastEvent = line
-				n.handleEvent(line)
+				if buf, err := json.Marshal(event); err == nil {
+					n.lastEvent = string(buf)
+				}
+				opened := n.openedWindows(event)
+				n.handleEvent(event)
 				n.mu.Unlock()
-			}
 
-			if err := scanner.Err(); err != nil {
-				logger.Log("error reading from niri event-stream: %v\n", err)
+				if n.onChange != nil {
+					n.onChange()
+				}
+
+				if n.onWindowOpened != nil {
+					for _, window := range opened {
+						n.onWindowOpened(window)
+					}
+				}
 			}
 
-			cmd.Wait()
+			stream.Close()
 
-			// Check how long the process ran
+			// Check how long the stream stayed up
 			uptime := time.Since(startTime)
 			if uptime >= cooldownPeriod {
-				// Process ran successfully for the cooldown period, reset backoff
+				// Stream ran successfully for the cooldown period, reset backoff
 				backoff = initialBackoff
-				logger.Log("niri event-stream process exited after %v, resetting backoff, restarting in %v\n", uptime.Round(time.Second), backoff)
+				logger.Log("%s event stream closed after %v, resetting backoff, restarting in %v\n", name, uptime.Round(time.Second), backoff)
 			} else {
-				// Process failed quickly, use exponential backoff
-				logger.Log("niri event-stream process exited after %v, restarting in %v\n", uptime.Round(time.Second), backoff)
+				// Stream failed quickly, use exponential backoff
+				logger.Log("%s event stream closed after %v, restarting in %v\n", name, uptime.Round(time.Second), backoff)
 				backoff = min(backoff*2, maxBackoff)
 			}
 
@@ -146,25 +164,67 @@ func (n *NiriEventListener) Listen() error {
 	return nil
 }
 
-func (n *NiriEventListener) handleEvent(line string) {
-	var event NiriEvent
-	if err := json.Unmarshal([]byte(line), &event); err != nil {
-		return
+// Returns the windows the event opens, or gives an app ID for the first time
+// (some applications set it after their window is shown). Niri reports each
+// window as it opens, while Hyprland and Sway send the whole window list, so
+// the list is compared with the model. Must be called with mu held, before the
+// event is applied to the model.
+func (n *EventListener) openedWindows(event compositor.Event) []compositor.Window {
+	var windows []compositor.Window
+
+	switch {
+	case event.WindowOpenedOrChanged != nil:
+		windows = []compositor.Window{event.WindowOpenedOrChanged.Window}
+	case event.WindowsChanged != nil:
+		// The first list is the windows that were open when the stream
+		// started, not ones that just opened
+		if _, ok := n.model.Windows(nil); !ok {
+			return nil
+		}
+		windows = event.WindowsChanged.Windows
+	default:
+		return nil
 	}
 
+	opened := make([]compositor.Window, 0)
+	for _, window := range windows {
+		if window.AppID == "" {
+			continue
+		}
+
+		if old, ok := n.model.Window(window.ID); ok && old.AppID != "" {
+			continue
+		}
+
+		opened = append(opened, window)
+	}
+
+	return opened
+}
+
+func (n *EventListener) handleEvent(event compositor.Event) {
+	n.model.Apply(event)
+
 	if event.WindowFocusChanged != nil {
-		windowID := event.WindowFocusChanged.ID
-		n.addWindowToHistory(windowID)
+		if event.WindowFocusChanged.ID != nil {
+			n.addWindowToHistory(*event.WindowFocusChanged.ID)
+		}
 	} else if event.WindowClosed != nil {
 		windowID := event.WindowClosed.ID
 		n.removeWindowFromHistory(windowID)
 	} else if event.WindowOpenedOrChanged != nil {
 		windowID := event.WindowOpenedOrChanged.Window.ID
 		n.addWindowToHistory(windowID)
+	} else if event.WindowsChanged != nil {
+		n.reconcileHistory()
+	} else {
+		return
 	}
+
+	n.scheduleSave()
 }
 
-func (n *NiriEventListener) addWindowToHistory(windowID uint64) {
+func (n *EventListener) addWindowToHistory(windowID uint64) {
 	// Remove the window ID if it already exists
 	for i, id := range n.windowHistory {
 		if id == windowID {
@@ -177,7 +237,7 @@ func (n *NiriEventListener) addWindowToHistory(windowID uint64) {
 	n.windowHistory = append(n.windowHistory, windowID)
 }
 
-func (n *NiriEventListener) removeWindowFromHistory(windowID uint64) {
+func (n *EventListener) removeWindowFromHistory(windowID uint64) {
 	// Remove the window ID from the history
 	for i, id := range n.windowHistory {
 		if id == windowID {
@@ -187,13 +247,13 @@ func (n *NiriEventListener) removeWindowFromHistory(windowID uint64) {
 	}
 }
 
-func (n *NiriEventListener) LastEvent() string {
+func (n *EventListener) LastEvent() string {
 	n.mu.RLock()
 	defer n.mu.RUnlock()
 	return n.lastEvent
 }
 
-func (n *NiriEventListener) WindowHistory() []uint64 {
+func (n *EventListener) WindowHistory() []uint64 {
 	n.mu.RLock()
 	defer n.mu.RUnlock()
 	// Return a copy to prevent external modification
@@ -202,12 +262,76 @@ func (n *NiriEventListener) WindowHistory() []uint64 {
 	return history
 }
 
-var eventListener *NiriEventListener
+// Call f after each event from the compositor has been handled. Must be
+// called before Listen.
+func (n *EventListener) OnChange(f func()) {
+	n.onChange = f
+}
+
+// Call f with each window that opens. Must be called before Listen.
+func (n *EventListener) OnWindowOpened(f func(compositor.Window)) {
+	n.onWindowOpened = f
+}
+
+// Returns the open windows, most recently focused first, or false if the
+// compositor hasn't sent the window list yet
+func (n *EventListener) Windows() ([]compositor.Window, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.model.Windows(n.windowHistory)
+}
+
+// Returns the workspaces, or false if the compositor hasn't sent the workspace
+// list yet
+func (n *EventListener) Workspaces() ([]compositor.Workspace, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.model.Workspaces()
+}
+
+var eventListener *EventListener
+var entryCache *EntryCache
+var overrideLearner *OverrideLearner
 var healthLoadShedder *LoadShedder
 var historyLoadShedder *LoadShedder
+var windowsLoadShedder *LoadShedder
+var workspacesLoadShedder *LoadShedder
+var entriesLoadShedder *LoadShedder
+var launchesLoadShedder *LoadShedder
+
+// Options for Start
+type Options struct {
+	// If not empty, also listen for HTTP on this TCP address, e.g.,
+	// "127.0.0.1:17324". By default the server only listens on a Unix socket.
+	TCPAddress string
+}
+
+// Start the server, tracking window focus using the given compositor's event
+// stream
+func Start(c compositor.Compositor, opts Options) error {
+	eventListener = NewEventListener(c)
+	entryCache = NewEntryCache()
+
+	// Sources running in this process read windows and workspaces from the
+	// event listener, rather than from the server over HTTP
+	wm.UseSnapshot(eventListener)
+	eventListener.OnChange(entryCache.Invalidate)
+
+	// Learn which applications windows belong to from what opens after a
+	// launch, and mark the applications as running once they are learned
+	overrideLearner = NewOverrideLearner()
+	overrideLearner.OnLearn(entryCache.Invalidate)
+	eventListener.OnWindowOpened(overrideLearner.WindowOpened)
+
+	eventListener.LoadHistory()
+	entryCache.Run()
+
+	// Pick up installed, removed and edited applications without waiting for
+	// the next request to notice
+	if err := watchApplications(entryCache.Invalidate); err != nil {
+		logger.Log("%v\n", err)
+	}
 
-func Start() error {
-	eventListener = &NiriEventListener{}
 	err := eventListener.Listen()
 	if err != nil {
 		return err
@@ -216,19 +340,78 @@ func Start() error {
 	// Initialize load shedders for each handler
 	healthLoadShedder = NewLoadShedder(maxRequestsPerMinute)
 	historyLoadShedder = NewLoadShedder(maxRequestsPerMinute)
+	windowsLoadShedder = NewLoadShedder(maxRequestsPerMinute)
+	workspacesLoadShedder = NewLoadShedder(maxRequestsPerMinute)
+	entriesLoadShedder = NewLoadShedder(maxRequestsPerMinute)
+	launchesLoadShedder = NewLoadShedder(maxRequestsPerMinute)
 
 	http.HandleFunc("/api/v1/health", healthHandler)
 	http.HandleFunc("/api/v1/history", historyHandler)
+	http.HandleFunc("/api/v1/windows", windowsHandler)
+	http.HandleFunc("/api/v1/workspaces", workspacesHandler)
+	http.HandleFunc("/api/v1/entries", entriesHandler)
+	http.HandleFunc("/api/v1/launches", launchesHandler)
 
-	addr := ":" + Port
-	logger.Log("Starting server on %s\n", addr)
-
-	err = http.ListenAndServe(addr, nil)
+	socketListener, err := listenUnix()
 	if err != nil {
 		return err
 	}
+	defer socketListener.Close()
 
-	return nil
+	errs := make(chan error, 2)
+
+	if opts.TCPAddress != "" {
+		tcpListener, err := net.Listen("tcp", opts.TCPAddress)
+		if err != nil {
+			return fmt.Errorf("error listening on %s: %w", opts.TCPAddress, err)
+		}
+
+		logger.Log("Starting server on %s\n", opts.TCPAddress)
+		go func() {
+			errs <- http.Serve(tcpListener, nil)
+		}()
+	}
+
+	logger.Log("Starting server on %s\n", socketListener.Addr())
+	go func() {
+		errs <- http.Serve(socketListener, nil)
+	}()
+
+	return <-errs
+}
+
+// Listen on the socket for this session, replacing a stale socket left by a
+// server that didn't exit cleanly
+func listenUnix() (net.Listener, error) {
+	socket := locations.ServerSocketFilename()
+
+	if err := os.MkdirAll(path.Dir(socket), 0o700); err != nil {
+		return nil, fmt.Errorf("error creating directory for %s: %w", socket, err)
+	}
+
+	if _, err := os.Stat(socket); err == nil {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another server is already listening on %s", socket)
+		}
+
+		if err := os.Remove(socket); err != nil {
+			return nil, fmt.Errorf("error removing stale socket %s: %w", socket, err)
+		}
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", socket, err)
+	}
+
+	// Only the user running the server may read window history
+	if err := os.Chmod(socket, 0o600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error setting permissions on %s: %w", socket, err)
+	}
+
+	return listener, nil
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -254,3 +437,94 @@ func historyHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(history)
 }
+
+func windowsHandler(w http.ResponseWriter, r *http.Request) {
+	if !windowsLoadShedder.Allow() {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("Too Many Requests"))
+		return
+	}
+
+	windows, ok := eventListener.Windows()
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Window list not yet received from compositor"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(windows)
+}
+
+func workspacesHandler(w http.ResponseWriter, r *http.Request) {
+	if !workspacesLoadShedder.Allow() {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("Too Many Requests"))
+		return
+	}
+
+	workspaces, ok := eventListener.Workspaces()
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Workspace list not yet received from compositor"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(workspaces)
+}
+
+func entriesHandler(w http.ResponseWriter, r *http.Request) {
+	if !entriesLoadShedder.Allow() {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("Too Many Requests"))
+		return
+	}
+
+	entries, err := entryCache.Entries(r.URL.Query().Get("source"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Usage changes with every `launchit read`, so sort on each request
+	// rather than when building the cache
+	if r.URL.Query().Get("sort") != "false" {
+		launcher.SortByUsage(entries)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
+
+// Record an application launched by `launchit read`, so that the window it
+// opens can be matched to it
+func launchesHandler(w http.ResponseWriter, r *http.Request) {
+	if !launchesLoadShedder.Allow() {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("Too Many Requests"))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Method Not Allowed"))
+		return
+	}
+
+	var launch client.Launch
+	if err := json.NewDecoder(r.Body).Decode(&launch); err != nil || launch.AppID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Expected a JSON object with an app-id"))
+		return
+	}
+
+	overrideLearner.Launched(launch.AppID)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
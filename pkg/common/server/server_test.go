@@ -0,0 +1,116 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/jplein/launchit/pkg/common/compositor"
+)
+
+// Apply an event the way Listen does, returning the windows it opened
+func feed(n *EventListener, event compositor.Event) []uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	opened := n.openedWindows(event)
+	n.handleEvent(event)
+
+	ids := make([]uint64, 0, len(opened))
+	for _, w := range opened {
+		ids = append(ids, w.ID)
+	}
+
+	return ids
+}
+
+func windowsChanged(windows ...compositor.Window) compositor.Event {
+	return compositor.Event{WindowsChanged: &compositor.WindowsChanged{Windows: windows}}
+}
+
+func TestOpenedWindows(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	n := NewEventListener(nil)
+	t.Cleanup(func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if n.saveTimer != nil {
+			n.saveTimer.Stop()
+		}
+	})
+
+	terminal := compositor.Window{ID: 1, AppID: "foot"}
+	editor := compositor.Window{ID: 2, AppID: "code-url-handler"}
+	untitled := compositor.Window{ID: 3}
+	browser := compositor.Window{ID: 4, AppID: "firefox"}
+
+	steps := []struct {
+		name  string
+		event compositor.Event
+		want  []uint64
+	}{
+		{
+			name:  "windows open when the stream starts",
+			event: windowsChanged(terminal),
+			want:  []uint64{},
+		},
+		{
+			// Hyprland and Sway send the whole list when a window opens
+			name:  "window list with a new window",
+			event: windowsChanged(terminal, editor),
+			want:  []uint64{2},
+		},
+		{
+			name:  "unchanged window list",
+			event: windowsChanged(editor, terminal),
+			want:  []uint64{},
+		},
+		{
+			name:  "new window without an app ID",
+			event: windowsChanged(terminal, editor, untitled),
+			want:  []uint64{},
+		},
+		{
+			name:  "window gets an app ID",
+			event: windowsChanged(terminal, editor, compositor.Window{ID: 3, AppID: "steam"}),
+			want:  []uint64{3},
+		},
+		{
+			// Niri reports each window as it opens
+			name:  "window opened",
+			event: compositor.Event{WindowOpenedOrChanged: &compositor.WindowOpenedOrChanged{Window: browser}},
+			want:  []uint64{4},
+		},
+		{
+			name:  "window changed",
+			event: compositor.Event{WindowOpenedOrChanged: &compositor.WindowOpenedOrChanged{Window: compositor.Window{ID: 4, AppID: "firefox", Title: "New Tab"}}},
+			want:  []uint64{},
+		},
+	}
+
+	for _, step := range steps {
+		if got := feed(n, step.event); !slices.Equal(got, step.want) {
+			t.Errorf("%s: opened %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+// A save before the window list arrives mustn't lose the history loaded from
+// disk, which is matched against the windows once they are known
+func TestSaveBeforeWindowList(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	n := NewEventListener(nil)
+	n.saved = []windowRecord{{AppID: "foot", Title: "~", Workspace: "1"}}
+
+	n.save()
+
+	if len(n.saved) != 1 {
+		t.Fatalf("saved history has %d windows after saving an empty history, want 1", len(n.saved))
+	}
+
+	feed(n, windowsChanged(compositor.Window{ID: 1, AppID: "foot", Title: "~"}))
+	if history := n.WindowHistory(); !slices.Equal(history, []uint64{1}) {
+		t.Errorf("history restored as %v, want [1]", history)
+	}
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/desktop"
+	"github.com/jplein/launchit/pkg/common/logger"
+)
+
+const (
+	// Changes to the files in an application directory
+	applicationDirMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_CLOSE_WRITE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB |
+		syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+	// New directories in an ancestor of an application directory that doesn't
+	// exist yet
+	ancestorDirMask = syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+	// Symlinks being replaced in the directory containing a symlink on the way
+	// to an application directory, e.g. a Nix profile being switched
+	symlinkDirMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+		syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+	// How long to wait after a change before reloading, so that installing a
+	// package causes a single reload
+	reloadDelay = 500 * time.Millisecond
+)
+
+// Watches the application directories with inotify, including directories that
+// don't exist yet (e.g., ~/.local/share/flatpak/exports/share/applications
+// before the first Flatpak is installed), and calls onChange when desktop files
+// are added, removed or changed. Directories are watched where their symlinks
+// lead, and the symlinks themselves are watched through the directories that
+// contain them, so that switching a Nix profile is noticed.
+type applicationWatcher struct {
+	fd       int
+	onChange func()
+
+	// Watched directories, by watch descriptor, and the mask of each
+	watches map[int32]string
+	masks   map[string]uint32
+
+	// The symlinks on the way to the application directories
+	symlinks map[string]bool
+
+	mu sync.Mutex
+}
+
+// Start watching the application directories in the background
+func watchApplications(onChange func()) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("error watching application directories: %w", err)
+	}
+
+	w := &applicationWatcher{
+		fd:       fd,
+		onChange: onChange,
+		watches:  make(map[int32]string),
+		masks:    make(map[string]uint32),
+		symlinks: make(map[string]bool),
+	}
+
+	w.sync()
+	go w.run()
+
+	return nil
+}
+
+// Update the watches to cover every application directory and its
+// subdirectories, the nearest existing ancestor of each application directory
+// that doesn't exist, and the directories containing the symlinks on the way
+// to them. Returns true if an application directory that wasn't watched before
+// is now, since it may already contain desktop files.
+func (w *applicationWatcher) sync() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	added := false
+
+	wanted := make(map[string]uint32)
+
+	dirs, err := desktop.ApplicationDirs()
+	if err != nil {
+		logger.Log("error getting application directories to watch: %v\n", err)
+		return false
+	}
+	for _, dir := range dirs {
+		wanted[resolve(dir)] = applicationDirMask
+	}
+
+	searchDirs, err := desktop.SearchDirs()
+	if err != nil {
+		logger.Log("error getting application directories to watch: %v\n", err)
+		return false
+	}
+
+	w.symlinks = make(map[string]bool)
+	for _, dir := range searchDirs {
+		for _, link := range symlinksTo(dir) {
+			w.symlinks[link] = true
+			wanted[path.Dir(link)] |= symlinkDirMask
+		}
+
+		if _, ok := wanted[resolve(dir)]; ok {
+			continue
+		}
+
+		if ancestor := existingAncestor(dir); ancestor != "" {
+			wanted[resolve(ancestor)] |= ancestorDirMask
+		}
+	}
+
+	for wd, dir := range w.watches {
+		if wanted[dir] != w.masks[dir] {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, wd)
+			delete(w.masks, dir)
+		}
+	}
+
+	for dir, mask := range wanted {
+		if _, ok := w.masks[dir]; ok {
+			continue
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, dir, mask)
+		if err != nil {
+			logger.Log("error watching %s: %v\n", dir, err)
+			continue
+		}
+
+		w.watches[int32(wd)] = dir
+		w.masks[dir] = mask
+
+		if mask&applicationDirMask == applicationDirMask {
+			added = true
+		}
+	}
+
+	return added
+}
+
+// Returns dir with its symlinks resolved, which is where inotify watches it,
+// or dir itself if it can't be resolved
+func resolve(dir string) string {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return dir
+	}
+
+	return resolved
+}
+
+// Returns the symlinks that are followed on the way to dir, including links
+// to links, each with the links before it resolved. Stops at the first part of
+// the path that doesn't exist.
+func symlinksTo(dir string) []string {
+	links := make([]string, 0)
+
+	current := "/"
+	rest := strings.Split(dir, "/")
+
+	// Give up on loops, like the kernel does
+	for hops := 0; len(rest) > 0 && hops < 40; {
+		name := rest[0]
+		rest = rest[1:]
+
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			current = path.Dir(current)
+			continue
+		}
+
+		next := path.Join(current, name)
+		target, err := os.Readlink(next)
+		if err != nil {
+			if _, err := os.Lstat(next); err != nil {
+				break
+			}
+
+			// Not a symlink
+			current = next
+			continue
+		}
+
+		links = append(links, next)
+		hops++
+
+		if path.IsAbs(target) {
+			current = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+
+	return links
+}
+
+// Returns the closest parent of dir that exists, or "" if there is none
+func existingAncestor(dir string) string {
+	for {
+		parent := path.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+}
+
+// Read events, and reload once they stop arriving
+func (w *applicationWatcher) run() {
+	var changed atomic.Bool
+	notify := make(chan struct{}, 1)
+
+	go func() {
+		timer := time.NewTimer(reloadDelay)
+		timer.Stop()
+
+		for {
+			select {
+			case <-notify:
+				timer.Reset(reloadDelay)
+			case <-timer.C:
+				// Watch directories that were created, and stop watching
+				// ancestors of directories that now exist
+				added := w.sync()
+
+				if changed.Swap(false) || added {
+					logger.Log("applications changed, reloading\n")
+					desktop.Invalidate()
+					w.onChange()
+				}
+			}
+		}
+	}()
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			logger.Log("error reading application directory changes, no longer watching: %v\n", err)
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+nameLen]), "\x00")
+			offset = nameStart + nameLen
+
+			if mask&syscall.IN_IGNORED != 0 {
+				w.forget(wd)
+				continue
+			}
+
+			reload, resync := w.classify(wd, mask, name)
+			if reload {
+				changed.Store(true)
+			}
+
+			if reload || resync {
+				select {
+				case notify <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// Drop a watch that the kernel removed, e.g. because its directory was deleted,
+// so that sync watches the directory again if it comes back
+func (w *applicationWatcher) forget(wd int32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if dir, ok := w.watches[wd]; ok {
+		delete(w.watches, wd)
+		delete(w.masks, dir)
+	}
+}
+
+// Decide what an event calls for. reload is true if it may change the list of
+// applications: a desktop file or directory changing in an application
+// directory, or an application directory disappearing. resync is true if only
+// the watches may need updating, because a directory appeared on the way to
+// an application directory that doesn't exist yet.
+func (w *applicationWatcher) classify(wd int32, mask uint32, name string) (reload bool, resync bool) {
+	// Events were lost, so anything may have changed
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return true, true
+	}
+
+	w.mu.Lock()
+	dir, ok := w.watches[wd]
+	watchMask := w.masks[dir]
+	symlink := w.symlinks[path.Join(dir, name)]
+	w.mu.Unlock()
+
+	if !ok {
+		return false, false
+	}
+
+	// A symlink on the way to an application directory was replaced or
+	// removed, so the directory may now be another one
+	if symlink {
+		return true, true
+	}
+
+	if watchMask&applicationDirMask != applicationDirMask {
+		return false, watchMask&ancestorDirMask == ancestorDirMask && mask&syscall.IN_ISDIR != 0
+	}
+
+	reload = mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_ISDIR) != 0 ||
+		strings.HasSuffix(name, ".desktop")
+
+	return reload, false
+}
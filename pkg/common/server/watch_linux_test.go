@@ -0,0 +1,42 @@
+package server
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// Switching a Nix profile only replaces a symlink, in a directory that isn't
+// on the way to the application directory once its symlinks are resolved
+func TestWatchSymlinkSwitch(t *testing.T) {
+	switchProfile := useSymlinkedApplications(t, "Foo")
+
+	c := NewEntryCache()
+	changed := make(chan struct{}, 1)
+	err := watchApplications(func() {
+		c.Invalidate()
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := applicationNames(t, c); !slices.Equal(names, []string{"Foo"}) {
+		t.Fatalf("applications %v, want [Foo]", names)
+	}
+
+	switchProfile("Bar")
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("switching the profile wasn't noticed")
+	}
+
+	if names := applicationNames(t, c); !slices.Equal(names, []string{"Bar"}) {
+		t.Errorf("applications after switching the profile %v, want [Bar]", names)
+	}
+}
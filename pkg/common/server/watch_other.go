@@ -0,0 +1,11 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+// Watching application directories needs inotify, so elsewhere the server
+// relies on the modification times checked on each request
+func watchApplications(onChange func()) error {
+	return errors.New("error watching application directories: not supported on this platform")
+}
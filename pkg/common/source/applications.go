@@ -2,41 +2,64 @@ package source
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
+	"path"
 	"strings"
-	"syscall"
 
+	"github.com/jplein/launchit/pkg/common/compositor"
 	"github.com/jplein/launchit/pkg/common/desktop"
+	"github.com/jplein/launchit/pkg/common/launch"
 	"github.com/jplein/launchit/pkg/common/logger"
-	"github.com/jplein/launchit/pkg/common/niri"
+	"github.com/jplein/launchit/pkg/common/server/client"
+	"github.com/jplein/launchit/pkg/common/wm"
 	"github.com/jplein/launchit/pkg/overrides"
 )
 
-type Applications struct{}
+type Applications struct {
+	// List applications that should be hidden, with the reason in their
+	// description, rather than leaving them out
+	ShowHidden bool
+}
 
 const (
 	idPrefix      = "app"
 	appSourceName = "applications"
 	appSourceType = "Application"
+
+	// Separates the desktop file from the action ID in the ID of an action
+	// entry, e.g. app:/usr/share/applications/firefox.desktop#new-private-window
+	actionSeparator = "#"
 )
 
 func (a *Applications) List() ([]Entry, error) {
-	apps, err := desktop.List()
+	db, err := desktop.Load()
 	if err != nil {
 		return nil, fmt.Errorf("error listing applications: %w", err)
 	}
+	apps := db.Apps()
+
+	windows, err := wm.ListWindows(true)
+	if err != nil {
+		logger.Log("error collecting window list: %v\n", err)
+		windows = []compositor.Window{}
+	}
 
-	windows, err := niri.ListWindows(true)
+	overridden, err := overrides.Load()
 	if err != nil {
-		logger.Log("error collecting window list: %w", err)
-		windows = []niri.WindowDescription{}
+		logger.Log("error reading overrides: %v\n", err)
 	}
 
 	entries := make([]Entry, 0)
 	for _, app := range apps {
 		desc := app.Name
-		window := getWindow(app, windows)
+
+		if reason := app.HiddenReason(); reason != "" {
+			if !a.ShowHidden {
+				continue
+			}
+			desc = fmt.Sprintf("%s (hidden: %s)", desc, reason)
+		}
+
+		window := getWindow(app, windows, db, overridden)
 
 		if window != nil {
 			desc = fmt.Sprintf("• %s", desc)
@@ -47,8 +70,22 @@ func (a *Applications) List() ([]Entry, error) {
 			Icon:        app.Icon,
 			ID:          idPrefix + ":" + app.Filename,
 			Type:        appSourceType,
+			Hidden:      searchTerms(app),
+			Comment:     app.Comment,
+			Generic:     app.GenericName,
 		}
 		entries = append(entries, entry)
+
+		for _, action := range app.Actions {
+			entries = append(entries, Entry{
+				Description: fmt.Sprintf("%s — %s", app.Name, action.Name),
+				Icon:        action.Icon,
+				ID:          entry.ID + actionSeparator + action.ID,
+				Type:        appSourceType,
+				Comment:     app.Comment,
+				Generic:     app.GenericName,
+			})
+		}
 	}
 
 	return entries, nil
@@ -58,6 +95,30 @@ func (a *Applications) Name() string {
 	return appSourceName
 }
 
+// Returns whether the desktop file of the entry is still installed, and has the
+// entry's action if it is one. Applications that List leaves out, like hidden
+// ones, still exist.
+func (a *Applications) Exists(id string) (bool, error) {
+	if !strings.HasPrefix(id, idPrefix+":") {
+		return false, fmt.Errorf("not an application: %s", id)
+	}
+
+	filename, actionID, isAction := strings.Cut(id[len(idPrefix)+1:], actionSeparator)
+
+	db, err := desktop.Load()
+	if err != nil {
+		return false, fmt.Errorf("error checking for application %s: %w", filename, err)
+	}
+
+	for _, app := range db.Apps() {
+		if app.Filename == filename {
+			return !isAction || app.Action(actionID) != nil, nil
+		}
+	}
+
+	return false, nil
+}
+
 func (a *Applications) Handle(entry Entry) error {
 	id := entry.ID
 	if !strings.HasPrefix(id, idPrefix+":") {
@@ -65,6 +126,7 @@ func (a *Applications) Handle(entry Entry) error {
 	}
 
 	filename := id[len(idPrefix)+1:]
+	filename, actionID, isAction := strings.Cut(filename, actionSeparator)
 	if filename == "" {
 		return fmt.Errorf("not a valid ID: filename is empty: %s", id)
 	}
@@ -74,21 +136,80 @@ func (a *Applications) Handle(entry Entry) error {
 		return fmt.Errorf("error reading desktop entry from file %s: %w", filename, err)
 	}
 
-	windows, err := niri.ListWindows(true)
+	// Actions always start something new (a new window, a new private window,
+	// etc.), so they don't switch to an existing window
+	if isAction {
+		action := app.Action(actionID)
+		if action == nil {
+			return fmt.Errorf("error running action: no action '%s' in %s", actionID, filename)
+		}
+
+		if activated, err := activate(app, action, nil); activated {
+			if err != nil {
+				return fmt.Errorf("error running action %s: %w", actionID, err)
+			}
+			recordLaunch(app)
+			return nil
+		}
+
+		argv, err := app.ActionArgv(*action, nil)
+		if err != nil {
+			return fmt.Errorf("error running action %s: %w", actionID, err)
+		}
+
+		if err = start(app, argv); err != nil {
+			return fmt.Errorf("error running action %s: %w", actionID, err)
+		}
+
+		recordLaunch(app)
+		return nil
+	}
+
+	windows, err := wm.ListWindows(true)
+	if err != nil {
+		logger.Log("error getting window list: %v\n", err)
+		windows = []compositor.Window{}
+	}
+
+	db, err := desktop.Load()
+	if err != nil {
+		logger.Log("error loading applications: %v\n", err)
+	}
+
+	overridden, err := overrides.Load()
 	if err != nil {
-		logger.Log("error getting window list from Niri: %w", err)
-		windows = []niri.WindowDescription{}
+		logger.Log("error reading overrides: %v\n", err)
 	}
 
-	window := getWindow(app, windows)
+	window := getWindow(app, windows, db, overridden)
 	if window != nil {
-		if err = niri.FocusWindow(window.ID); err != nil {
+		c, err := wm.Current()
+		if err != nil {
+			return fmt.Errorf("error switching to window with ID %d: %w", window.ID, err)
+		}
+
+		if err = c.FocusWindow(window.ID); err != nil {
 			return fmt.Errorf("error switching to window with ID %d: %w", window.ID, err)
 		}
 	} else {
-		if err = a.exec(app); err != nil {
+		if activated, err := activate(app, nil, nil); activated {
+			if err != nil {
+				return fmt.Errorf("error running application: %w", err)
+			}
+			recordLaunch(app)
+			return nil
+		}
+
+		argv, err := app.Argv(nil)
+		if err != nil {
+			return fmt.Errorf("error running application: %w", err)
+		}
+
+		if err = start(app, argv); err != nil {
 			return fmt.Errorf("error running application: %w", err)
 		}
+
+		recordLaunch(app)
 	}
 
 	return nil
@@ -98,48 +219,111 @@ func (a *Applications) Prefix() string {
 	return idPrefix
 }
 
-func (a *Applications) exec(app desktop.App) error {
-	sh, err := exec.LookPath("sh")
-	if err != nil {
-		return fmt.Errorf("error starting application: could not find sh in the PATH")
+// Returns the text to put in an application entry's hidden field, so that
+// launchers also match it by what it is (e.g. "browser" for Firefox) and by the
+// name of its program
+func searchTerms(app desktop.App) string {
+	terms := make([]string, 0)
+	if app.GenericName != "" {
+		terms = append(terms, app.GenericName)
+	}
+	terms = append(terms, app.Keywords...)
+	if app.Comment != "" {
+		terms = append(terms, app.Comment)
 	}
 
-	if app.Exec == "" {
-		return fmt.Errorf("error starting application from file %s: Exec entry is missing or blank", app.Filename)
+	if args, err := desktop.SplitExec(app.Exec); err == nil {
+		terms = append(terms, path.Base(args[0]))
 	}
 
-	if app.Path != "" {
-		if err := os.Chdir(app.Path); err != nil {
-			return fmt.Errorf("error starting application: error setting working directory '%s': %w", app.Path, err)
+	return strings.Join(terms, " ")
+}
+
+// Start a command from the application's desktop file in the background, with
+// the launch method from the config, in a terminal if the application needs
+// one. The command is run directly, not through a shell.
+//
+// argv: The program and its arguments, from App.Argv or App.ActionArgv
+func start(app desktop.App, argv []string) error {
+	if app.Terminal {
+		wrapped, err := launch.InTerminal(argv)
+		if err != nil {
+			return fmt.Errorf("error starting application from file %s: %w", app.Filename, err)
 		}
+		argv = wrapped
 	}
 
-	env := os.Environ()
-
-	args := []string{sh, "-c", app.Exec}
-	err = syscall.Exec(sh, args, env)
+	err := launch.Start(argv, launch.Options{ID: app.ID, Name: app.Name, Dir: app.Path})
 	if err != nil {
-		return fmt.Errorf("error starting application: error executing %s with arguments %v: %w", sh, args, err)
+		return fmt.Errorf("error starting application from file %s: %w", app.Filename, err)
 	}
 
 	return nil
 }
 
+// Start a D-Bus activatable application, or one of its actions, by calling it
+// over D-Bus. Returns false if the application isn't D-Bus activatable, or if
+// the call failed but the application has an Exec line to run instead.
+//
+// action: The action to run, or nil to start the application
+//
+// uris: Files and URLs to open
+func activate(app desktop.App, action *desktop.Action, uris []string) (bool, error) {
+	if !app.DBusActivatable {
+		return false, nil
+	}
+
+	var err error
+	exec := app.Exec
+	if action != nil {
+		err = launch.ActivateAction(app.ID, action.ID)
+		exec = action.Exec
+	} else {
+		err = launch.Activate(app.ID, uris)
+	}
+
+	if err == nil {
+		return true, nil
+	}
+
+	if exec == "" {
+		return true, err
+	}
+
+	logger.Log("%v, running its Exec line instead\n", err)
+	return false, nil
+}
+
+// Tell the server about the launch, so that it can learn which windows are the
+// application's if their app ID doesn't match it. Applications run in a
+// terminal are left out, since their window is the terminal's.
+func recordLaunch(app desktop.App) {
+	if app.Terminal {
+		return
+	}
+
+	if err := client.RecordLaunch(app.ID); err != nil {
+		logger.Log("error telling the server about the launch of %s: %v\n", app.ID, err)
+	}
+}
+
 // Returns the most recently accessed open window for the application, or nil if
 // there is no such window
 //
 // entry: An application entry
 //
 // windows: The list of open windows, with the most recently accessed windows at
-// the beginning of the list, as returned by niri.ListWindows()
-func getWindow(app desktop.App, windows []niri.WindowDescription) *niri.WindowDescription {
+// the beginning of the list, as returned by wm.ListWindows()
+//
+// db: The desktop database, used to find the application of windows whose app
+// ID isn't the application's ID (see Database.ForWindow), as the window list
+// does. May be nil.
+//
+// overridden: The overrides in effect, from overrides.Load. May be nil.
+func getWindow(app desktop.App, windows []compositor.Window, db *desktop.Database, overridden *overrides.Set) *compositor.Window {
 	id := app.ID
 
-	or, err := overrides.ByAppID(app.ID)
-	if err != nil {
-		logger.Log("error getting window ID for app %s: %w", app.ID, err)
-	}
-
+	or := overridden.ByAppID(app.ID)
 	if or != nil {
 		id = or.WindowAppID
 	}
@@ -148,6 +332,15 @@ func getWindow(app desktop.App, windows []niri.WindowDescription) *niri.WindowDe
 		if window.AppID == id {
 			return &window
 		}
+
+		// An override says which windows are the application's, so don't guess
+		if or != nil || db == nil {
+			continue
+		}
+
+		if windowApp := db.ForWindow(window.AppID); windowApp != nil && windowApp.ID == app.ID {
+			return &window
+		}
 	}
 
 	return nil
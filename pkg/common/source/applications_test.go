@@ -0,0 +1,59 @@
+package source
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplicationExists(t *testing.T) {
+	dataHome := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataHome)
+	t.Setenv("XDG_DATA_DIRS", filepath.Join(dataHome, "missing"))
+
+	dir := filepath.Join(dataHome, "applications")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"editor.desktop": "[Desktop Entry]\nType=Application\nName=Editor\nExec=editor\nActions=new-window;\n\n" +
+			"[Desktop Action new-window]\nName=New Window\nExec=editor --new-window\n",
+		"helper.desktop":  "[Desktop Entry]\nType=Application\nName=Helper\nExec=helper\nNoDisplay=true\n",
+		"deleted.desktop": "[Desktop Entry]\nType=Application\nName=Deleted\nExec=deleted\nHidden=true\n",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	id := func(name string) string {
+		return idPrefix + ":" + filepath.Join(dir, name)
+	}
+
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{id: id("editor.desktop"), want: true},
+		{id: id("editor.desktop") + actionSeparator + "new-window", want: true},
+		{id: id("editor.desktop") + actionSeparator + "new-private-window", want: false},
+
+		// Left out of the list, but still installed
+		{id: id("helper.desktop"), want: true},
+
+		{id: id("deleted.desktop"), want: false},
+		{id: id("uninstalled.desktop"), want: false},
+	}
+
+	a := &Applications{}
+	for _, test := range tests {
+		got, err := a.Exists(test.id)
+		if err != nil {
+			t.Errorf("Exists(%s) returned error: %v", test.id, err)
+		} else if got != test.want {
+			t.Errorf("Exists(%s) = %v, want %v", test.id, got, test.want)
+		}
+	}
+}
@@ -1,13 +1,11 @@
 package source
 
 import (
-	"bytes"
 	_ "embed"
 	"fmt"
 	"os"
-	"os/exec"
 
-	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/common/launch"
 	"github.com/jplein/launchit/pkg/common/state/locations"
 	"go.yaml.in/yaml/v4"
 )
@@ -61,14 +59,8 @@ func (c *Commands) Handle(entry Entry) error {
 
 	for _, c := range c.commands {
 		if commandPrefix+":"+c.ID == entry.ID {
-			var stdout, stderr bytes.Buffer
-			cmd := exec.Command(c.Executable, c.Args...)
-			cmd.Stdout = &stdout
-			cmd.Stderr = &stderr
-
-			if err := cmd.Run(); err != nil {
-				logger.Log("command stdout: %s\n", stdout.String())
-				logger.Log("command stderr: %s\n", stderr.String())
+			argv := append([]string{c.Executable}, c.Args...)
+			if err := launch.Start(argv, launch.Options{ID: c.ID, Name: c.Description}); err != nil {
 				return fmt.Errorf("error running command: %w", err)
 			}
 
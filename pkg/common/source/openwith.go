@@ -0,0 +1,154 @@
+package source
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jplein/launchit/pkg/common/desktop"
+	"github.com/jplein/launchit/pkg/common/logger"
+)
+
+// Lists the applications that can open a set of files or URLs, and opens them
+// with the chosen one
+type OpenWith struct {
+	// The files and URLs to open. If empty, List returns no entries.
+	URIs []string
+}
+
+const (
+	openWithPrefix     = "open"
+	openWithSourceName = "open-with"
+	openWithSourceType = "Open With"
+
+	// Separates the desktop file from the encoded list of files in an entry ID,
+	// e.g. open:/usr/share/applications/org.gnome.Evince.desktop?uri=%2Ftmp%2Fa.pdf
+	openWithQuerySeparator = "?"
+)
+
+// Lists the applications that can open every one of the URIs, the default
+// application for the first URI's type first
+func (o *OpenWith) List() ([]Entry, error) {
+	if len(o.URIs) == 0 {
+		return []Entry{}, nil
+	}
+
+	apps, err := desktop.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing applications to open with: %w", err)
+	}
+
+	mimeApps := desktop.ReadMimeApps()
+
+	var handlers []desktop.App
+	for i, uri := range o.URIs {
+		mimeType := desktop.MimeType(uri)
+		logger.Log("opening %s as %s\n", uri, mimeType)
+
+		found := mimeApps.Handlers(apps, mimeType)
+		if i == 0 {
+			handlers = found
+			continue
+		}
+
+		// Keep the order from the first URI, dropping applications that can't
+		// open this one
+		capable := make(map[string]bool, len(found))
+		for _, app := range found {
+			capable[app.Filename] = true
+		}
+
+		kept := make([]desktop.App, 0, len(handlers))
+		for _, app := range handlers {
+			if capable[app.Filename] {
+				kept = append(kept, app)
+			}
+		}
+		handlers = kept
+	}
+
+	query := url.Values{"uri": o.URIs}.Encode()
+
+	entries := make([]Entry, 0, len(handlers))
+	for _, app := range handlers {
+		entries = append(entries, Entry{
+			Description: app.Name,
+			Icon:        app.Icon,
+			ID:          openWithPrefix + ":" + app.Filename + openWithQuerySeparator + query,
+			Type:        openWithSourceType,
+			Hidden:      searchTerms(app),
+			Comment:     app.Comment,
+			Generic:     app.GenericName,
+		})
+	}
+
+	return entries, nil
+}
+
+func (o *OpenWith) Name() string {
+	return openWithSourceName
+}
+
+func (o *OpenWith) Prefix() string {
+	return openWithPrefix
+}
+
+// Open the files and URLs in the entry's ID with its application. If the
+// application only takes one file at a time, it is started once per file.
+func (o *OpenWith) Handle(entry Entry) error {
+	filename, uris, err := parseOpenWithID(entry.ID)
+	if err != nil {
+		return err
+	}
+
+	app, err := desktop.FromFile(filename)
+	if err != nil {
+		return fmt.Errorf("error reading desktop entry from file %s: %w", filename, err)
+	}
+
+	// D-Bus activatable applications open the whole list at once
+	if activated, err := activate(app, nil, uris); activated {
+		if err != nil {
+			return fmt.Errorf("error opening %v with %s: %w", uris, app.Name, err)
+		}
+		return nil
+	}
+
+	for _, batch := range app.Batches(uris) {
+		argv, err := app.Argv(batch)
+		if err != nil {
+			return fmt.Errorf("error opening %v with %s: %w", batch, app.Name, err)
+		}
+
+		if err := start(app, argv); err != nil {
+			return fmt.Errorf("error opening %v with %s: %w", batch, app.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Usage is recorded per application, not per set of files
+func (o *OpenWith) UsageKey(id string) string {
+	key, _, _ := strings.Cut(id, openWithQuerySeparator)
+	return key
+}
+
+// Returns the desktop file and the URIs from an entry ID
+func parseOpenWithID(id string) (string, []string, error) {
+	if !strings.HasPrefix(id, openWithPrefix+":") {
+		return "", nil, fmt.Errorf("not an open-with entry: %s", id)
+	}
+
+	filename, query, _ := strings.Cut(id[len(openWithPrefix)+1:], openWithQuerySeparator)
+	if filename == "" {
+		return "", nil, fmt.Errorf("not a valid ID: filename is empty: %s", id)
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", nil, fmt.Errorf("not a valid ID: error parsing files to open: %s: %w", id, err)
+	}
+
+	return filename, values["uri"], nil
+}
@@ -9,11 +9,16 @@ import (
 )
 
 type Entry struct {
-	Description string
-	ID          string
-	Icon        string
-	Type        string
-	Hidden      string
+	Description string `json:"description"`
+	ID          string `json:"id"`
+	Icon        string `json:"icon"`
+	Type        string `json:"type"`
+	Hidden      string `json:"hidden"`
+
+	// Optional details for the comment and generic columns, e.g. an
+	// application's Comment and GenericName
+	Comment string `json:"comment,omitempty"`
+	Generic string `json:"generic,omitempty"`
 }
 
 // Read an entry from a string. The string should contain a line with fields
@@ -87,22 +92,71 @@ func (s *SourceSet) List() ([]Entry, error) {
 	return entries, nil
 }
 
+// Options for the default source set
+type Options struct {
+	// List applications that would normally be hidden (NoDisplay, a missing
+	// TryExec program, OnlyShowIn or NotShowIn), for debugging
+	ShowHidden bool
+}
+
 func DefaultSourceSet() (*SourceSet, error) {
-	appSource := &Applications{}
+	return DefaultSourceSetWithOptions(Options{})
+}
+
+func DefaultSourceSetWithOptions(opts Options) (*SourceSet, error) {
+	appSource := &Applications{ShowHidden: opts.ShowHidden}
 	windowsSource := &WindowList{}
 	commandsSource := &Commands{}
 	workspacesSource := &Workspaces{}
 
-	return NewSourceSet([]Source{appSource, windowsSource, commandsSource, workspacesSource})
+	// Only lists entries when given files to open, but is needed to handle the
+	// entries written by launchit open
+	openWithSource := &OpenWith{}
+
+	return NewSourceSet([]Source{appSource, windowsSource, commandsSource, workspacesSource, openWithSource})
 }
 
 func (s *SourceSet) Handle(entry Entry) error {
-	id := entry.ID
+	source := s.SourceFor(entry.ID)
+	if source == nil {
+		return fmt.Errorf("no handler found for %s", entry.ID)
+	}
+
+	return source.Handle(entry)
+}
+
+// Returns the source that produces entries with the given ID, or nil if there
+// is none
+func (s *SourceSet) SourceFor(id string) Source {
 	for _, source := range s.Sources {
 		if strings.HasPrefix(id, source.Prefix()) {
-			return source.Handle(entry)
+			return source
 		}
 	}
 
-	return fmt.Errorf("no handler found for %s", id)
+	return nil
+}
+
+// Implemented by sources whose entry IDs contain details that shouldn't be
+// recorded in the usage history, such as the files to open
+type UsageKeyer interface {
+	// Returns the ID to record usage under for the entry with the given ID
+	UsageKey(id string) string
+}
+
+// Implemented by sources that can tell whether an entry still exists, for
+// entries that List may leave out, such as hidden applications
+type ExistenceChecker interface {
+	// Returns whether the entry with the given ID still exists
+	Exists(id string) (bool, error)
+}
+
+// Returns the ID to record usage under for the entry with the given ID. This is
+// the ID itself, unless its source implements UsageKeyer.
+func (s *SourceSet) UsageKey(id string) string {
+	if k, ok := s.SourceFor(id).(UsageKeyer); ok {
+		return k.UsageKey(id)
+	}
+
+	return id
 }
@@ -7,7 +7,7 @@ import (
 
 	"github.com/jplein/launchit/pkg/common/desktop"
 	"github.com/jplein/launchit/pkg/common/logger"
-	"github.com/jplein/launchit/pkg/common/niri"
+	"github.com/jplein/launchit/pkg/common/wm"
 	"github.com/jplein/launchit/pkg/overrides"
 )
 
@@ -20,28 +20,33 @@ const (
 type WindowList struct{}
 
 func (w *WindowList) List() ([]Entry, error) {
-	windows, err := niri.ListWindows(true)
+	windows, err := wm.ListWindows(true)
 	if err != nil {
 		return nil, fmt.Errorf("error getting window list: %w", err)
 	}
 
+	db, err := desktop.Load()
+	if err != nil {
+		logger.Log("error loading applications: %v\n", err)
+	}
+
+	overridden, err := overrides.Load()
+	if err != nil {
+		logger.Log("error reading overrides: %v\n", err)
+	}
+
 	entries := make([]Entry, 0)
 
 	for _, window := range windows {
 		appID := window.AppID
 
-		or, err := overrides.ByWindowAppID(appID)
-		if err != nil {
-			logger.Log("error reading overrides: %v", err)
-		}
-
-		if or != nil {
+		if or := overridden.ByWindowAppID(appID); or != nil {
 			appID = or.AppID
 		}
 
-		desktopEntry, err := desktop.FromID(appID)
-		if err != nil {
-			logger.Log("error getting desktop entry %s: %v\n", window.AppID, err)
+		var desktopEntry *desktop.App
+		if db != nil {
+			desktopEntry = db.ForWindow(appID)
 		}
 
 		var icon string
@@ -78,7 +83,7 @@ func (w *WindowList) Handle(entry Entry) error {
 	id := entry.ID
 
 	if !strings.HasPrefix(id, windowListPrefix+":") {
-		return fmt.Errorf("not a Niri window: %s", id)
+		return fmt.Errorf("not a window: %s", id)
 	}
 
 	windowId := id[len(windowListPrefix)+1:]
@@ -92,14 +97,17 @@ func (w *WindowList) Handle(entry Entry) error {
 		}
 	}
 
-	windowInt64, err := strconv.ParseInt(windowId, 10, 64)
+	windowInt, err := strconv.ParseUint(windowId, 10, 64)
 	if err != nil {
 		return fmt.Errorf("error focusing window: error reading window ID '%s' as integer: %w", windowId, err)
 	}
 
-	windowInt := int(windowInt64)
+	c, err := wm.Current()
+	if err != nil {
+		return fmt.Errorf("error switching to window %d: %w", windowInt, err)
+	}
 
-	if err := niri.FocusWindow(windowInt); err != nil {
+	if err := c.FocusWindow(windowInt); err != nil {
 		return fmt.Errorf("error switching to window %d: %w", windowInt, err)
 	}
 
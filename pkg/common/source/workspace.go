@@ -1,13 +1,12 @@
 package source
 
 import (
-	"bytes"
 	"fmt"
-	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/jplein/launchit/pkg/common/logger"
-	"github.com/jplein/launchit/pkg/common/niri"
+	"github.com/jplein/launchit/pkg/common/wm"
 )
 
 const (
@@ -22,16 +21,27 @@ const (
 type Workspaces struct{}
 
 func (w *Workspaces) List() ([]Entry, error) {
-	workspaces, err := niri.ListWorkspaces()
+	c, err := wm.Current()
 	if err != nil {
-		return nil, fmt.Errorf("error getting workspace list from Niri: %w", err)
+		return nil, fmt.Errorf("error getting workspace list: %w", err)
+	}
+
+	workspaces, err := wm.ListWorkspaces()
+	if err != nil {
+		return nil, fmt.Errorf("error getting workspace list from %s: %w", c.Name(), err)
 	}
 
 	entries := make([]Entry, 0)
 
 	for _, workspace := range workspaces {
+		// Workspaces without an index, like named Sway workspaces without a
+		// number, can't be switched to by index
+		if workspace.Index <= 0 {
+			continue
+		}
+
 		switchEntry := Entry{
-			Description: fmt.Sprintf("Niri: Switch to workspace %d", workspace.Index),
+			Description: fmt.Sprintf("%s: Switch to workspace %d", c.Name(), workspace.Index),
 			ID:          fmt.Sprintf("%s:%d%s", workspacePrefix, workspace.Index, workspaceSwitchSuffix),
 			Type:        workspaceSourceType,
 			Icon:        workspaceIcon,
@@ -40,7 +50,7 @@ func (w *Workspaces) List() ([]Entry, error) {
 		entries = append(entries, switchEntry)
 
 		moveEntry := Entry{
-			Description: fmt.Sprintf("Niri: Move active window to workspace %d", workspace.Index),
+			Description: fmt.Sprintf("%s: Move active window to workspace %d", c.Name(), workspace.Index),
 			ID:          fmt.Sprintf("%s:%d%s", workspacePrefix, workspace.Index, workspaceMoveSuffix),
 			Type:        workspaceSourceType,
 			Icon:        workspaceIcon,
@@ -60,7 +70,7 @@ func (w *Workspaces) Handle(entry Entry) error {
 	id := entry.ID
 
 	if !strings.HasPrefix(id, workspacePrefix) {
-		return fmt.Errorf("not a Niri workspace: %s", id)
+		return fmt.Errorf("not a workspace: %s", id)
 	}
 
 	widAndSuffix := id[len(workspacePrefix)+1:]
@@ -68,35 +78,37 @@ func (w *Workspaces) Handle(entry Entry) error {
 		return fmt.Errorf("not a valid ID: No workspace ID and type suffix")
 	}
 
-	var cmd *exec.Cmd
+	c, err := wm.Current()
+	if err != nil {
+		return fmt.Errorf("error handling workspace entry %s: %w", id, err)
+	}
+
+	var suffix string
 	switch {
 	case strings.HasSuffix(widAndSuffix, workspaceSwitchSuffix):
-		wid := widAndSuffix[:(len(widAndSuffix) - len(workspaceSwitchSuffix))]
-		cmd = exec.Command("niri", "msg", "action", "focus-workspace", wid)
+		suffix = workspaceSwitchSuffix
 	case strings.HasSuffix(widAndSuffix, workspaceMoveSuffix):
-		wid := widAndSuffix[:(len(widAndSuffix) - len(workspaceMoveSuffix))]
-		cmd = exec.Command("niri", "msg", "action", "move-window-to-workspace", wid)
+		suffix = workspaceMoveSuffix
 	default:
 		return fmt.Errorf("not a valid ID: does not end with %s or %s", workspaceSwitchSuffix, workspaceMoveSuffix)
 	}
+	wid := widAndSuffix[:(len(widAndSuffix) - len(suffix))]
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	index, err := strconv.Atoi(wid)
+	if err != nil {
+		return fmt.Errorf("not a valid ID: error reading workspace index '%s' as integer: %w", wid, err)
+	}
+	if index <= 0 {
+		return fmt.Errorf("not a valid ID: workspace index %d is not positive", index)
+	}
 
-	logger.Log("Workspaces -> Handle: about to run command %v", cmd)
+	logger.Log("Workspaces -> Handle: %s workspace %d\n", suffix, index)
 
-	if err := cmd.Run(); err != nil {
-		if stdout.Len() > 0 {
-			logger.Log("niri focus-window stdout: %s\n", stdout.String())
-		}
-		if stderr.Len() > 0 {
-			logger.Log("niri focus-window stderr: %s\n", stderr.String())
-		}
-		return fmt.Errorf("error running command %v", cmd)
+	if suffix == workspaceSwitchSuffix {
+		return c.SwitchWorkspace(index)
 	}
 
-	return nil
+	return c.MoveWindowToWorkspace(index)
 }
 
 func (w *Workspaces) Prefix() string {
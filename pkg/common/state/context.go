@@ -0,0 +1,78 @@
+package state
+
+import "time"
+
+// Context describes the circumstances in which an entry was chosen, so that
+// entries chosen in similar circumstances can be ranked higher
+type Context struct {
+	// The key of the focused workspace (see compositor.Workspace.Key), or empty
+	// if unknown
+	Workspace string
+
+	// The app ID of the focused window, or empty if unknown
+	FocusedAppID string
+}
+
+// ContextCounts records how many times an entry was chosen in each context
+type ContextCounts struct {
+	// Number of launches recorded with context
+	Total int `json:"total"`
+
+	// Launches by workspace key
+	Workspaces map[string]int `json:"workspaces,omitempty"`
+
+	// Launches by the app ID of the focused window
+	Apps map[string]int `json:"apps,omitempty"`
+
+	// Launches by hour of the day, 0-23, in local time
+	Hours map[int]int `json:"hours,omitempty"`
+}
+
+func (c *ContextCounts) add(ctx Context, t time.Time) {
+	if c.Workspaces == nil {
+		c.Workspaces = make(map[string]int)
+	}
+	if c.Apps == nil {
+		c.Apps = make(map[string]int)
+	}
+	if c.Hours == nil {
+		c.Hours = make(map[int]int)
+	}
+
+	c.Total++
+	if ctx.Workspace != "" {
+		c.Workspaces[ctx.Workspace]++
+	}
+	if ctx.FocusedAppID != "" {
+		c.Apps[ctx.FocusedAppID]++
+	}
+	c.Hours[t.Local().Hour()]++
+}
+
+// Returns how well ctx and t match the contexts the entry was chosen in, from
+// 0 (never chosen in this context) to 1 (always chosen in this context). Each
+// of workspace, focused application and hour of the day counts for a third.
+// Launches an hour either side of t count half, since routines aren't exact.
+func (c *ContextCounts) match(ctx Context, t time.Time) float64 {
+	if c == nil || c.Total == 0 {
+		return 0
+	}
+
+	total := float64(c.Total)
+
+	workspace := 0.0
+	if ctx.Workspace != "" {
+		workspace = float64(c.Workspaces[ctx.Workspace]) / total
+	}
+
+	app := 0.0
+	if ctx.FocusedAppID != "" {
+		app = float64(c.Apps[ctx.FocusedAppID]) / total
+	}
+
+	hour := t.Local().Hour()
+	hourCount := float64(c.Hours[hour]) + 0.5*float64(c.Hours[(hour+23)%24]+c.Hours[(hour+1)%24])
+	hourMatch := min(hourCount/total, 1)
+
+	return (workspace + app + hourMatch) / 3
+}
@@ -0,0 +1,102 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+)
+
+// Run f while holding an exclusive lock on file, so that concurrent launchit
+// processes don't interleave their read-modify-write cycles. The lock is taken
+// on a separate file, since the file itself is replaced on every write.
+func WithLock(file string, f func() error) error {
+	if err := os.MkdirAll(path.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("error locking %s: %w", file, err)
+	}
+
+	lockFile := file + ".lock"
+	fh, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("error locking %s: error opening %s: %w", file, lockFile, err)
+	}
+	defer fh.Close()
+
+	if err := syscall.Flock(int(fh.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("error locking %s: %w", file, err)
+	}
+	defer syscall.Flock(int(fh.Fd()), syscall.LOCK_UN)
+
+	return f()
+}
+
+// Returns the path of the backup kept for file
+func backupFilename(file string) string {
+	return file + ".bak"
+}
+
+// Replace the contents of file atomically: readers see either the old or the
+// new contents, never a partial write
+func WriteFileAtomic(file string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(file, data, perm, false)
+}
+
+// Replace the contents of file atomically, as WriteFileAtomic does
+//
+// backup: Whether to keep the previous contents as a backup, to recover from if
+// the file is later found to be corrupt
+func writeFileAtomic(file string, data []byte, perm os.FileMode, backup bool) error {
+	dir := path.Dir(file)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("error writing %s: %w", file, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+path.Base(file)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error writing %s: error creating temporary file: %w", file, err)
+	}
+	tmpName := tmp.Name()
+
+	// Clean up the temporary file if anything fails before the rename
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing %s: %w", tmpName, err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing %s: error syncing: %w", tmpName, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %w", tmpName, err)
+	}
+
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return fmt.Errorf("error writing %s: error setting permissions: %w", tmpName, err)
+	}
+
+	// Hard-link the current file as the backup, so that the file itself never
+	// goes missing
+	if _, err := os.Stat(file); backup && err == nil {
+		backupFile := backupFilename(file)
+		os.Remove(backupFile)
+		if err := os.Link(file, backupFile); err != nil {
+			return fmt.Errorf("error backing up %s: %w", file, err)
+		}
+	}
+
+	if err := os.Rename(tmpName, file); err != nil {
+		return fmt.Errorf("error writing %s: error renaming %s: %w", file, tmpName, err)
+	}
+	committed = true
+
+	return nil
+}
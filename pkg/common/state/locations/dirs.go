@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 )
 
 const (
@@ -40,3 +41,48 @@ func ConfigDirectory() (string, error) {
 
 	return path.Join(xdgConfigHome, appName), nil
 }
+
+// Returns the directory for sockets and other files that only live as long as
+// the user's session
+func RuntimeDirectory() string {
+	xdgRuntimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if xdgRuntimeDir == "" {
+		return path.Join(os.TempDir(), fmt.Sprintf("%s-%d", appName, os.Getuid()))
+	}
+
+	return path.Join(xdgRuntimeDir, appName)
+}
+
+// Returns a name for the graphical session, so that each compositor instance
+// (e.g., a nested session, or a second seat) gets its own server
+func SessionName() string {
+	candidates := []string{
+		os.Getenv("WAYLAND_DISPLAY"),
+		os.Getenv("NIRI_SOCKET"),
+		os.Getenv("HYPRLAND_INSTANCE_SIGNATURE"),
+		os.Getenv("SWAYSOCK"),
+		os.Getenv("I3SOCK"),
+		os.Getenv("DISPLAY"),
+	}
+
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+
+		name := strings.TrimSuffix(path.Base(c), ".sock")
+		name = strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+				return r
+			}
+			return '_'
+		}, name)
+
+		if name != "" && name != "." {
+			return name
+		}
+	}
+
+	return "default"
+}
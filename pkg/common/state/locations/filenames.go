@@ -27,6 +27,7 @@ func LogFilenameForSubcommand(subcommand string) (string, error) {
 
 const (
 	baseRecentFilename = "recent.json"
+	baseUsageFilename  = "usage.json"
 )
 
 func RecentFilename() (string, error) {
@@ -38,6 +39,77 @@ func RecentFilename() (string, error) {
 	return path.Join(stateDirectory, baseRecentFilename), nil
 }
 
+const (
+	baseWindowHistoryFilename = "window-history.json"
+)
+
+func WindowHistoryFilename() (string, error) {
+	stateDirectory, err := StateDirectory()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(stateDirectory, baseWindowHistoryFilename), nil
+}
+
+const (
+	baseIconCacheFilename = "icon-cache.json"
+)
+
+// Returns the path of the cache of icon lookups, from icon names to files
+func IconCacheFilename() (string, error) {
+	stateDirectory, err := StateDirectory()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(stateDirectory, baseIconCacheFilename), nil
+}
+
+const (
+	baseDesktopCacheFilename = "desktop-cache.json"
+)
+
+// Returns the path of the cache of parsed desktop files
+func DesktopCacheFilename() (string, error) {
+	stateDirectory, err := StateDirectory()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(stateDirectory, baseDesktopCacheFilename), nil
+}
+
+const (
+	baseLearnedOverridesFilename = "learned-overrides.json"
+)
+
+// Returns the path of the overrides learned by watching which windows open
+// after an application is launched
+func LearnedOverridesFilename() (string, error) {
+	stateDirectory, err := StateDirectory()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(stateDirectory, baseLearnedOverridesFilename), nil
+}
+
+// Returns the path of the socket `launchit server` listens on for the current
+// session
+func ServerSocketFilename() string {
+	return path.Join(RuntimeDirectory(), SessionName()+".sock")
+}
+
+func UsageFilename() (string, error) {
+	stateDirectory, err := StateDirectory()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(stateDirectory, baseUsageFilename), nil
+}
+
 type XDGDirectory string
 
 const (
@@ -97,6 +169,10 @@ func Initialize(dir XDGDirectory, relPath string, buf []byte, mode os.FileMode)
 		return file, nil
 	}
 
+	if err = os.MkdirAll(path.Dir(file), 0o755); err != nil {
+		return "", fmt.Errorf("error initializing %s: %w", relPath, err)
+	}
+
 	if err = os.WriteFile(file, buf, mode); err != nil {
 		return "", fmt.Errorf("error initializing %s: %w", relPath, err)
 	}
@@ -0,0 +1,468 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/config"
+	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/common/state/locations"
+)
+
+const (
+	// Maximum number of entries to keep usage for. When there are more, the
+	// entries with the lowest scores are dropped.
+	maxEntries = 500
+
+	// Number of launch times to keep per entry. Older launches still count
+	// towards Count, and are assumed to be spread like the recent ones.
+	maxLaunches = 10
+)
+
+// Usage records how often and how recently an entry was chosen
+type Usage struct {
+	// Total number of times the entry was chosen
+	Count int `json:"count"`
+
+	// The most recent launch times, oldest first
+	Launches []time.Time `json:"launches"`
+
+	// The contexts the entry was chosen in
+	Context *ContextCounts `json:"context,omitempty"`
+}
+
+// Returns the time the entry was last chosen
+func (u Usage) Last() time.Time {
+	if len(u.Launches) == 0 {
+		return time.Time{}
+	}
+
+	return u.Launches[len(u.Launches)-1]
+}
+
+// Returns the frecency score of the entry: each recent launch contributes a
+// weight that halves every halfLife, and the total is scaled up to account for
+// launches that are no longer individually recorded
+func (u Usage) Score(now time.Time, halfLife time.Duration) float64 {
+	if len(u.Launches) == 0 {
+		return 0
+	}
+
+	sum := 0.0
+	for _, t := range u.Launches {
+		age := max(now.Sub(t), 0)
+		sum += math.Exp2(-float64(age) / float64(halfLife))
+	}
+
+	return sum * float64(u.Count) / float64(len(u.Launches))
+}
+
+// The version of the usage database format written by this version of
+// launchit. Bump it, and add a migration, when the format changes.
+const currentVersion = 1
+
+// Migrations from each version of the database to the next, applied to the
+// decoded JSON. migrations[n] upgrades version n to version n+1. Files written
+// before the version field was added are version 1.
+var migrations = map[int]func(doc map[string]any) error{}
+
+// Database holds usage for every entry that has been chosen
+type Database struct {
+	Version int               `json:"version"`
+	Entries map[string]*Usage `json:"entries"`
+}
+
+func newDatabase() *Database {
+	return &Database{Version: currentVersion, Entries: make(map[string]*Usage)}
+}
+
+// Read the usage database. If it doesn't exist yet, it is created from the
+// list of recent entries kept by older versions, if there is one. If it is
+// corrupt, it is set aside and the backup from before the last write is used
+// instead. Both write to the state directory, so they are done holding the
+// lock.
+func Load() (*Database, error) {
+	file, err := locations.UsageFilename()
+	if err != nil {
+		return nil, fmt.Errorf("error reading usage: %w", err)
+	}
+
+	db, err := readDatabase(file)
+	if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, errCorrupt) {
+		return db, err
+	}
+
+	err = WithLock(file, func() error {
+		db, err = load(file)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Returned by readDatabase when the file can't be parsed
+var errCorrupt = errors.New("usage file is corrupt")
+
+// Read the usage file without changing anything. Returns an error wrapping
+// os.ErrNotExist if it doesn't exist, or errCorrupt if it can't be parsed.
+func readDatabase(file string) (*Database, error) {
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading usage: error reading from %s: %w", file, err)
+	}
+
+	db, err := parseDatabase(contents)
+	if err == nil {
+		return db, nil
+	}
+
+	var newer *newerVersionError
+	if errors.As(err, &newer) {
+		return nil, fmt.Errorf("error reading usage from %s: %w", file, err)
+	}
+
+	return nil, fmt.Errorf("error reading usage from %s: %w: %w", file, errCorrupt, err)
+}
+
+// Read the usage file, migrating or recovering it if necessary, as described
+// for Load. Must be called holding the lock, since another process may have
+// just migrated or recovered the file, or be about to.
+func load(file string) (*Database, error) {
+	db, err := readDatabase(file)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return migrateRecent()
+	case errors.Is(err, errCorrupt):
+		logger.Log("%v, recovering from backup\n", err)
+		return recoverDatabase(file)
+	}
+
+	return db, err
+}
+
+type newerVersionError struct {
+	version int
+}
+
+func (e *newerVersionError) Error() string {
+	return fmt.Sprintf("file has version %d, but this version of launchit only understands up to version %d", e.version, currentVersion)
+}
+
+// Decode the database, migrating it to the current version if necessary
+func parseDatabase(contents []byte) (*Database, error) {
+	doc := make(map[string]any)
+	if err := json.Unmarshal(contents, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing JSON: %w", err)
+	}
+
+	version := 1
+	if v, ok := doc["version"].(float64); ok {
+		version = int(v)
+	}
+
+	if version > currentVersion {
+		return nil, &newerVersionError{version: version}
+	}
+
+	for ; version < currentVersion; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration from version %d", version)
+		}
+
+		if err := migrate(doc); err != nil {
+			return nil, fmt.Errorf("error migrating from version %d: %w", version, err)
+		}
+	}
+	doc["version"] = currentVersion
+
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding migrated JSON: %w", err)
+	}
+
+	db := newDatabase()
+	if err := json.Unmarshal(migrated, db); err != nil {
+		return nil, fmt.Errorf("error parsing JSON: %w", err)
+	}
+
+	if db.Entries == nil {
+		db.Entries = make(map[string]*Usage)
+	}
+
+	return db, nil
+}
+
+// Move the corrupt file aside, and read the backup instead. If the backup is
+// also unreadable, start with an empty database. Either way it is written back
+// to the usage file, so that the next load doesn't find the file missing and
+// start over. Must be called holding the lock.
+func recoverDatabase(file string) (*Database, error) {
+	corrupt := file + ".corrupt"
+	if err := os.Rename(file, corrupt); err != nil {
+		logger.Log("error moving corrupt usage file %s aside: %v\n", file, err)
+	} else {
+		logger.Log("moved corrupt usage file to %s\n", corrupt)
+	}
+
+	db, err := readBackup(file)
+	if err != nil {
+		logger.Log("%v, starting with empty usage\n", err)
+		db = newDatabase()
+	}
+
+	if err := db.Save(); err != nil {
+		return nil, fmt.Errorf("error recovering usage: %w", err)
+	}
+
+	return db, nil
+}
+
+// Read the backup kept for the usage file
+func readBackup(file string) (*Database, error) {
+	backup := backupFilename(file)
+	contents, err := os.ReadFile(backup)
+	if err != nil {
+		return nil, fmt.Errorf("error reading usage backup %s: %w", backup, err)
+	}
+
+	db, err := parseDatabase(contents)
+	if err != nil {
+		return nil, fmt.Errorf("error reading usage backup %s: %w", backup, err)
+	}
+
+	return db, nil
+}
+
+func (db *Database) Save() error {
+	file, err := locations.UsageFilename()
+	if err != nil {
+		return fmt.Errorf("error writing usage: %w", err)
+	}
+
+	db.Version = currentVersion
+
+	output, err := json.Marshal(db)
+	if err != nil {
+		return fmt.Errorf("error writing usage: error marshaling to JSON: %w", err)
+	}
+
+	// Keep a backup, since usage can't be rebuilt if the file is lost
+	if err = writeFileAtomic(file, output, 0o644, true); err != nil {
+		return fmt.Errorf("error writing usage: %w", err)
+	}
+
+	return nil
+}
+
+// Load the database, apply f to it, and save it, while holding a lock so that
+// concurrent updates aren't lost
+func update(f func(db *Database) error) error {
+	file, err := locations.UsageFilename()
+	if err != nil {
+		return fmt.Errorf("error updating usage: %w", err)
+	}
+
+	return WithLock(file, func() error {
+		db, err := load(file)
+		if err != nil {
+			return err
+		}
+
+		if err := f(db); err != nil {
+			return err
+		}
+
+		return db.Save()
+	})
+}
+
+// Record that the entry with the given ID was chosen at time t, in the given
+// context
+func (db *Database) Record(id string, t time.Time, ctx Context) {
+	u, ok := db.Entries[id]
+	if !ok {
+		u = &Usage{}
+		db.Entries[id] = u
+	}
+
+	u.Count++
+	u.Launches = append(u.Launches, t)
+	if len(u.Launches) > maxLaunches {
+		u.Launches = u.Launches[len(u.Launches)-maxLaunches:]
+	}
+
+	if u.Context == nil {
+		u.Context = &ContextCounts{}
+	}
+	u.Context.add(ctx, t)
+
+	db.prune(t)
+}
+
+// Returns a function that gives the frecency score of an entry ID, using the
+// half-life from the config. Entries that have never been chosen score 0.
+//
+// ctx: The current context. Entries historically chosen in a similar context
+// get a boost, up to the factor set by the config's context-weight.
+func (db *Database) Scorer(now time.Time, ctx Context) func(id string) float64 {
+	ranking := config.GetOrDefault().Ranking
+	halfLife := ranking.HalfLifeDuration()
+	weight := ranking.ContextWeightValue()
+
+	return func(id string) float64 {
+		u, ok := db.Entries[id]
+		if !ok {
+			return 0
+		}
+		return u.Score(now, halfLife) * (1 + weight*u.Context.match(ctx, now))
+	}
+}
+
+// Drop the lowest-scoring entries if there are more than maxEntries
+func (db *Database) prune(now time.Time) {
+	if len(db.Entries) <= maxEntries {
+		return
+	}
+
+	score := db.Scorer(now, Context{})
+	ids := db.IDs()
+	sort.SliceStable(ids, func(i, j int) bool {
+		return score(ids[i]) > score(ids[j])
+	})
+
+	for _, id := range ids[maxEntries:] {
+		delete(db.Entries, id)
+	}
+}
+
+// Returns the IDs of all entries, most recently chosen first
+func (db *Database) IDs() []string {
+	ids := make([]string, 0, len(db.Entries))
+	for id := range db.Entries {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		li, lj := db.Entries[ids[i]].Last(), db.Entries[ids[j]].Last()
+		if !li.Equal(lj) {
+			return li.After(lj)
+		}
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+// Returns the IDs of entries that have been chosen, most recent first
+func Get() ([]string, error) {
+	db, err := Load()
+	if err != nil {
+		return nil, fmt.Errorf("error getting recent commands: %w", err)
+	}
+
+	return db.IDs(), nil
+}
+
+// Record that the entry with the given ID was chosen now, in the given
+// context. Entries excluded in the config are not recorded.
+func Add(id string, ctx Context) error {
+	if config.GetOrDefault().History.IsExcluded(id) {
+		return nil
+	}
+
+	err := update(func(db *Database) error {
+		db.Record(id, time.Now(), ctx)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error adding '%s' to recent commands: %w", id, err)
+	}
+
+	return nil
+}
+
+// Remove the entries with the given IDs. Returns the number of entries that
+// were removed.
+func Remove(ids ...string) (int, error) {
+	removed := 0
+	err := update(func(db *Database) error {
+		for _, id := range ids {
+			if _, ok := db.Entries[id]; ok {
+				delete(db.Entries, id)
+				removed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error removing entries: %w", err)
+	}
+
+	return removed, nil
+}
+
+// Remove all entries
+func Clear() error {
+	err := update(func(db *Database) error {
+		db.Entries = make(map[string]*Usage)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error clearing usage: %w", err)
+	}
+
+	return nil
+}
+
+// Build a usage database from recent.json, the most-recently-used list kept by
+// older versions. Each entry is given a single launch, a minute apart, so the
+// old order is preserved. The old file is renamed so that it isn't migrated
+// again. Must be called holding the lock.
+func migrateRecent() (*Database, error) {
+	db := newDatabase()
+
+	file, err := locations.RecentFilename()
+	if err != nil {
+		return db, nil
+	}
+
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return db, nil
+	}
+
+	recent := make([]string, 0)
+	if err := json.Unmarshal(contents, &recent); err != nil {
+		logger.Log("error migrating %s: error parsing as JSON: %v\n", file, err)
+		return db, nil
+	}
+
+	now := time.Now()
+	for i, id := range recent {
+		db.Entries[id] = &Usage{
+			Count:    1,
+			Launches: []time.Time{now.Add(-time.Duration(i) * time.Minute)},
+		}
+	}
+
+	if err := db.Save(); err != nil {
+		return nil, fmt.Errorf("error migrating %s: %w", file, err)
+	}
+
+	if err := os.Rename(file, file+".migrated"); err != nil {
+		logger.Log("error renaming %s after migrating it: %v\n", file, err)
+	}
+
+	logger.Log("migrated %d recent entries from %s\n", len(recent), file)
+
+	return db, nil
+}
@@ -0,0 +1,102 @@
+package state
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/jplein/launchit/pkg/common/state/locations"
+)
+
+// Point the state and config directories at temporary ones, and return the
+// state directory
+func useTempState(t *testing.T) string {
+	t.Helper()
+
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	dir, err := locations.StateDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestLoadMigratesRecent(t *testing.T) {
+	useTempState(t)
+
+	recent, _ := locations.RecentFilename()
+	if err := os.WriteFile(recent, []byte(`["app:foo", "app:bar"]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := db.IDs(); !slices.Equal(ids, []string{"app:foo", "app:bar"}) {
+		t.Errorf("migrated IDs %v, want [app:foo app:bar]", ids)
+	}
+
+	if _, err := os.Stat(recent + ".migrated"); err != nil {
+		t.Errorf("recent.json wasn't renamed after migrating: %v", err)
+	}
+
+	// The migrated database was saved, so loading again reads it
+	db, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Entries) != 2 {
+		t.Errorf("reloaded %d entries, want 2", len(db.Entries))
+	}
+}
+
+func TestLoadRecoversCorruptFile(t *testing.T) {
+	useTempState(t)
+
+	if err := Add("app:foo", Context{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Add("app:bar", Context{}); err != nil {
+		t.Fatal(err)
+	}
+
+	file, _ := locations.UsageFilename()
+	if err := os.WriteFile(file, []byte(`{"entries": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The backup is from before the last write
+	if ids := db.IDs(); !slices.Equal(ids, []string{"app:foo"}) {
+		t.Errorf("recovered IDs %v, want [app:foo]", ids)
+	}
+
+	if _, err := os.Stat(file + ".corrupt"); err != nil {
+		t.Errorf("corrupt file wasn't moved aside: %v", err)
+	}
+
+	// The recovered entries were written back, so the next update keeps them
+	if err := Add("app:baz", Context{}); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := db.IDs(); !slices.Equal(ids, []string{"app:baz", "app:foo"}) {
+		t.Errorf("IDs after recovering and adding %v, want [app:baz app:foo]", ids)
+	}
+}
@@ -0,0 +1,429 @@
+package sway
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/compositor"
+	"github.com/jplein/launchit/pkg/common/logger"
+)
+
+// Message types from the i3/Sway IPC protocol
+const (
+	msgRunCommand    uint32 = 0
+	msgGetWorkspaces uint32 = 1
+	msgSubscribe     uint32 = 2
+	msgGetTree       uint32 = 4
+
+	// Events have the high bit set
+	eventWorkspace uint32 = 0x80000000
+	eventWindow    uint32 = 0x80000003
+)
+
+const (
+	magic          = "i3-ipc"
+	headerSize     = len(magic) + 8
+	requestTimeout = 2 * time.Second
+)
+
+// Sway implements compositor.Compositor using the i3 IPC protocol, which is
+// spoken by both Sway and i3
+type Sway struct {
+	name   string
+	socket string
+}
+
+// Create a client for the running Sway or i3 instance, found using $SWAYSOCK
+// or $I3SOCK
+func New() (*Sway, error) {
+	if socket := os.Getenv("SWAYSOCK"); socket != "" {
+		return NewWithSocket("Sway", socket), nil
+	}
+
+	if socket := os.Getenv("I3SOCK"); socket != "" {
+		return NewWithSocket("i3", socket), nil
+	}
+
+	return nil, errors.New("error connecting to Sway: neither SWAYSOCK nor I3SOCK is set")
+}
+
+// Create a client for the instance listening on socket
+//
+// name: The name to show for the compositor, e.g., "Sway"
+func NewWithSocket(name string, socket string) *Sway {
+	return &Sway{name: name, socket: socket}
+}
+
+func (s *Sway) Name() string {
+	return s.name
+}
+
+type node struct {
+	ID               uint64    `json:"id"`
+	Name             string    `json:"name"`
+	Type             string    `json:"type"`
+	Focused          bool      `json:"focused"`
+	Urgent           bool      `json:"urgent"`
+	Focus            []uint64  `json:"focus"`
+	AppID            *string   `json:"app_id"`
+	Window           *int      `json:"window"`
+	WindowProperties *struct { // Only present for X11 windows
+		Class    string `json:"class"`
+		Instance string `json:"instance"`
+	} `json:"window_properties"`
+	Nodes         []node `json:"nodes"`
+	FloatingNodes []node `json:"floating_nodes"`
+}
+
+type workspace struct {
+	ID      int64  `json:"id"`
+	Num     int    `json:"num"`
+	Name    string `json:"name"`
+	Output  string `json:"output"`
+	Visible bool   `json:"visible"`
+	Focused bool   `json:"focused"`
+}
+
+type commandResult struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+}
+
+// Returns windows in an approximation of most-recently-focused order, using
+// each container's focus stack
+func (s *Sway) ListWindows() ([]compositor.Window, error) {
+	var root node
+	if err := s.request(msgGetTree, "", &root); err != nil {
+		return nil, fmt.Errorf("error getting windows from %s: %w", s.name, err)
+	}
+
+	windows := make([]compositor.Window, 0)
+	collectWindows(root, 0, &windows)
+	return windows, nil
+}
+
+// Walk the tree, appending windows to result. Children are visited in the
+// order of their parent's focus stack, so more recently focused windows come
+// first.
+func collectWindows(n node, workspaceID int64, result *[]compositor.Window) {
+	if n.Type == "workspace" {
+		workspaceID = int64(n.ID)
+	}
+
+	if n.isWindow() {
+		*result = append(*result, n.toWindow(workspaceID))
+		return
+	}
+
+	children := append(append([]node{}, n.Nodes...), n.FloatingNodes...)
+	byID := make(map[uint64]node, len(children))
+	for _, c := range children {
+		byID[c.ID] = c
+	}
+
+	visited := make(map[uint64]bool, len(children))
+	for _, id := range n.Focus {
+		if c, ok := byID[id]; ok && !visited[id] {
+			visited[id] = true
+			collectWindows(c, workspaceID, result)
+		}
+	}
+
+	for _, c := range children {
+		if !visited[c.ID] {
+			collectWindows(c, workspaceID, result)
+		}
+	}
+}
+
+func (n node) isWindow() bool {
+	return (n.Type == "con" || n.Type == "floating_con") && (n.AppID != nil || n.Window != nil)
+}
+
+func (n node) toWindow(workspaceID int64) compositor.Window {
+	appID := ""
+	if n.AppID != nil {
+		appID = *n.AppID
+	} else if n.WindowProperties != nil {
+		appID = n.WindowProperties.Class
+	}
+
+	return compositor.Window{
+		ID:          n.ID,
+		Title:       n.Name,
+		AppID:       appID,
+		WorkspaceID: workspaceID,
+		IsFocused:   n.Focused,
+		IsUrgent:    n.Urgent,
+	}
+}
+
+func (s *Sway) FocusWindow(id uint64) error {
+	if err := s.runCommand(fmt.Sprintf("[con_id=%d] focus", id)); err != nil {
+		return fmt.Errorf("error switching to window %d: %w", id, err)
+	}
+
+	return nil
+}
+
+// Returns workspaces in the order the compositor reports them. Workspaces
+// without a number are given an index of 0, since `workspace number` can't
+// switch to them.
+func (s *Sway) ListWorkspaces() ([]compositor.Workspace, error) {
+	var workspaces []workspace
+	if err := s.request(msgGetWorkspaces, "", &workspaces); err != nil {
+		return nil, fmt.Errorf("error getting workspaces from %s: %w", s.name, err)
+	}
+
+	result := make([]compositor.Workspace, 0, len(workspaces))
+	for _, w := range workspaces {
+		result = append(result, w.toWorkspace())
+	}
+
+	return result, nil
+}
+
+func (w workspace) toWorkspace() compositor.Workspace {
+	index := w.Num
+	if index < 0 {
+		index = 0
+	}
+
+	return compositor.Workspace{
+		ID:        w.ID,
+		Index:     index,
+		Name:      w.Name,
+		Output:    w.Output,
+		IsActive:  w.Visible,
+		IsFocused: w.Focused,
+	}
+}
+
+func (s *Sway) SwitchWorkspace(index int) error {
+	if err := s.runCommand(fmt.Sprintf("workspace number %d", index)); err != nil {
+		return fmt.Errorf("error switching to workspace %d: %w", index, err)
+	}
+
+	return nil
+}
+
+// Moves the focused window and follows it, as Niri does
+func (s *Sway) MoveWindowToWorkspace(index int) error {
+	cmd := fmt.Sprintf("move container to workspace number %d; workspace number %d", index, index)
+	if err := s.runCommand(cmd); err != nil {
+		return fmt.Errorf("error moving window to workspace %d: %w", index, err)
+	}
+
+	return nil
+}
+
+func (s *Sway) runCommand(cmd string) error {
+	var results []commandResult
+	if err := s.request(msgRunCommand, cmd, &results); err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		if !r.Success {
+			return fmt.Errorf("command '%s' failed: %s", cmd, r.Error)
+		}
+	}
+
+	return nil
+}
+
+// Send a single request on a new connection, and decode the JSON reply into v
+func (s *Sway) request(msgType uint32, payload string, v any) error {
+	conn, err := net.DialTimeout("unix", s.socket, requestTimeout)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", s.socket, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := writeMessage(conn, msgType, []byte(payload)); err != nil {
+		return err
+	}
+
+	_, reply, err := readMessage(conn)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(reply, v); err != nil {
+		logger.Log("%s IPC reply:\n", s.name)
+		logger.Log("%s\n", string(reply))
+		return fmt.Errorf("error parsing JSON: %w", err)
+	}
+
+	return nil
+}
+
+func writeMessage(w io.Writer, msgType uint32, payload []byte) error {
+	buf := make([]byte, headerSize, headerSize+len(payload))
+	copy(buf, magic)
+	binary.NativeEndian.PutUint32(buf[len(magic):], uint32(len(payload)))
+	binary.NativeEndian.PutUint32(buf[len(magic)+4:], msgType)
+	buf = append(buf, payload...)
+
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("error sending IPC message: %w", err)
+	}
+
+	return nil
+}
+
+func readMessage(r io.Reader) (uint32, []byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, fmt.Errorf("error reading IPC message header: %w", err)
+	}
+
+	if string(header[:len(magic)]) != magic {
+		return 0, nil, fmt.Errorf("error reading IPC message: invalid magic string %q", header[:len(magic)])
+	}
+
+	length := binary.NativeEndian.Uint32(header[len(magic):])
+	msgType := binary.NativeEndian.Uint32(header[len(magic)+4:])
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, fmt.Errorf("error reading IPC message payload: %w", err)
+	}
+
+	return msgType, payload, nil
+}
+
+type windowEvent struct {
+	Change    string `json:"change"`
+	Container node   `json:"container"`
+}
+
+type eventStream struct {
+	s       *Sway
+	conn    net.Conn
+	pending []compositor.Event
+}
+
+func (s *Sway) EventStream() (compositor.EventStream, error) {
+	conn, err := net.Dial("unix", s.socket)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", s.socket, err)
+	}
+
+	if err := writeMessage(conn, msgSubscribe, []byte(`["window","workspace"]`)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	_, reply, err := readMessage(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var result commandResult
+	if err := json.Unmarshal(reply, &result); err != nil || !result.Success {
+		conn.Close()
+		return nil, fmt.Errorf("error subscribing to %s events: %s", s.name, strings.TrimSpace(string(reply)))
+	}
+
+	stream := &eventStream{s: s, conn: conn}
+
+	// Match Niri's behaviour of starting the stream with the full window and
+	// workspace lists
+	if err := stream.queueWindows(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := stream.queueWorkspaces(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+func (e *eventStream) Next() (compositor.Event, error) {
+	for len(e.pending) == 0 {
+		msgType, payload, err := readMessage(e.conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return compositor.Event{}, io.EOF
+			}
+			return compositor.Event{}, fmt.Errorf("error reading from %s event stream: %w", e.s.name, err)
+		}
+
+		if err := e.handleMessage(msgType, payload); err != nil {
+			logger.Log("error handling %s event: %v\n", e.s.name, err)
+		}
+	}
+
+	event := e.pending[0]
+	e.pending = e.pending[1:]
+	return event, nil
+}
+
+func (e *eventStream) Close() error {
+	return e.conn.Close()
+}
+
+func (e *eventStream) handleMessage(msgType uint32, payload []byte) error {
+	if msgType == eventWorkspace {
+		// Workspace events describe a single workspace, without the visibility
+		// of the others, so refresh the whole list
+		return e.queueWorkspaces()
+	}
+
+	if msgType != eventWindow {
+		return nil
+	}
+
+	var we windowEvent
+	if err := json.Unmarshal(payload, &we); err != nil {
+		return fmt.Errorf("error parsing window event: %w", err)
+	}
+
+	switch we.Change {
+	case "focus":
+		id := we.Container.ID
+		e.pending = append(e.pending, compositor.Event{WindowFocusChanged: &compositor.WindowFocusChanged{ID: &id}})
+	case "close":
+		e.pending = append(e.pending, compositor.Event{WindowClosed: &compositor.WindowClosed{ID: we.Container.ID}})
+	case "new", "title", "move", "urgent", "floating":
+		// The container in these events doesn't say which workspace the window
+		// is on, so refresh the whole list
+		return e.queueWindows()
+	}
+
+	return nil
+}
+
+func (e *eventStream) queueWindows() error {
+	windows, err := e.s.ListWindows()
+	if err != nil {
+		return err
+	}
+
+	e.pending = append(e.pending, compositor.Event{WindowsChanged: &compositor.WindowsChanged{Windows: windows}})
+	return nil
+}
+
+func (e *eventStream) queueWorkspaces() error {
+	workspaces, err := e.s.ListWorkspaces()
+	if err != nil {
+		return err
+	}
+
+	e.pending = append(e.pending, compositor.Event{WorkspacesChanged: &compositor.WorkspacesChanged{Workspaces: workspaces}})
+	return nil
+}
@@ -0,0 +1,297 @@
+package sway
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jplein/launchit/pkg/common/compositor"
+)
+
+// A stand-in for Sway's IPC socket: it answers requests with canned replies,
+// and hands the test the connections that subscribe to events
+type fakeSway struct {
+	tree       string
+	workspaces string
+
+	// The reply to RUN_COMMAND
+	commandReply string
+
+	// The commands received, and the payload of each subscription
+	commands   []string
+	subscribed []string
+
+	// Connections that subscribed to events
+	events chan net.Conn
+
+	mu sync.Mutex
+}
+
+func startFake(t *testing.T) (*fakeSway, string) {
+	t.Helper()
+
+	socket := path.Join(t.TempDir(), "sway-ipc.sock")
+	f := &fakeSway{
+		tree:         `{"id": 1, "type": "root"}`,
+		workspaces:   `[]`,
+		commandReply: `[{"success": true}]`,
+		events:       make(chan net.Conn, 1),
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(t, conn)
+		}
+	}()
+
+	return f, socket
+}
+
+// Answer requests on a connection until it is closed, or until it subscribes
+// to events, after which the test writes to it
+func (f *fakeSway) serve(t *testing.T, conn net.Conn) {
+	for {
+		msgType, payload, err := readMessage(conn)
+		if err != nil {
+			conn.Close()
+			return
+		}
+
+		f.mu.Lock()
+		var reply string
+		switch msgType {
+		case msgGetTree:
+			reply = f.tree
+		case msgGetWorkspaces:
+			reply = f.workspaces
+		case msgRunCommand:
+			f.commands = append(f.commands, string(payload))
+			reply = f.commandReply
+		case msgSubscribe:
+			f.subscribed = append(f.subscribed, string(payload))
+			reply = `{"success": true}`
+		default:
+			t.Errorf("unexpected message type %d", msgType)
+			reply = `{"success": false}`
+		}
+		f.mu.Unlock()
+
+		if err := writeMessage(conn, msgType, []byte(reply)); err != nil {
+			t.Errorf("error replying: %v", err)
+		}
+
+		if msgType == msgSubscribe {
+			t.Cleanup(func() { conn.Close() })
+			f.events <- conn
+			return
+		}
+	}
+}
+
+// Change the canned replies, or look at the requests received, holding the lock
+func (f *fakeSway) update(change func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	change()
+}
+
+func TestMessageFraming(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeMessage(&buf, msgRunCommand, []byte("focus")); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := buf.Bytes()
+	if got := string(raw[:len(magic)]); got != "i3-ipc" {
+		t.Errorf("message starts with %q, want i3-ipc", got)
+	}
+	if got := binary.NativeEndian.Uint32(raw[6:]); got != 5 {
+		t.Errorf("header has length %d, want 5", got)
+	}
+	if got := binary.NativeEndian.Uint32(raw[10:]); got != msgRunCommand {
+		t.Errorf("header has type %d, want %d", got, msgRunCommand)
+	}
+
+	msgType, payload, err := readMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != msgRunCommand || string(payload) != "focus" {
+		t.Errorf("read message type %d with payload %q, want type %d with payload focus", msgType, payload, msgRunCommand)
+	}
+
+	bad := append([]byte("i3-ipx"), raw[len(magic):]...)
+	if _, _, err := readMessage(bytes.NewReader(bad)); err == nil {
+		t.Error("read a message with the wrong magic string without error")
+	}
+
+	if _, _, err := readMessage(bytes.NewReader(raw[:len(raw)-1])); err == nil {
+		t.Error("read a truncated message without error")
+	}
+}
+
+// Two workspaces on one output. The first has a split container focused on
+// its X11 window, and a floating window focused more recently than the split.
+const testTree = `{
+	"id": 1, "type": "root", "focus": [100],
+	"nodes": [{
+		"id": 100, "type": "output", "name": "DP-1", "focus": [10, 20],
+		"nodes": [
+			{
+				"id": 10, "type": "workspace", "name": "1", "focus": [4, 11],
+				"nodes": [{
+					"id": 11, "type": "con", "focus": [3, 2],
+					"nodes": [
+						{"id": 2, "type": "con", "name": "~", "app_id": "foot"},
+						{"id": 3, "type": "con", "name": "Mozilla Firefox", "app_id": null, "window": 6291459,
+							"window_properties": {"class": "firefox", "instance": "Navigator"}, "focused": true}
+					]
+				}],
+				"floating_nodes": [
+					{"id": 4, "type": "floating_con", "name": "Volume Control", "app_id": "pavucontrol"}
+				]
+			},
+			{
+				"id": 20, "type": "workspace", "name": "2", "focus": [],
+				"nodes": [
+					{"id": 6, "type": "con", "nodes": []},
+					{"id": 5, "type": "con", "name": "main.go", "app_id": "code", "urgent": true}
+				]
+			}
+		]
+	}]
+}`
+
+var testWindows = []compositor.Window{
+	{ID: 4, Title: "Volume Control", AppID: "pavucontrol", WorkspaceID: 10},
+	{ID: 3, Title: "Mozilla Firefox", AppID: "firefox", WorkspaceID: 10, IsFocused: true},
+	{ID: 2, Title: "~", AppID: "foot", WorkspaceID: 10},
+	{ID: 5, Title: "main.go", AppID: "code", WorkspaceID: 20, IsUrgent: true},
+}
+
+const testWorkspaces = `[
+	{"id": 10, "num": 1, "name": "1", "output": "DP-1", "visible": true, "focused": true},
+	{"id": 20, "num": -1, "name": "web", "output": "DP-1"}
+]`
+
+var testWorkspaceList = []compositor.Workspace{
+	{ID: 10, Index: 1, Name: "1", Output: "DP-1", IsActive: true, IsFocused: true},
+	{ID: 20, Index: 0, Name: "web", Output: "DP-1"},
+}
+
+func TestListWindows(t *testing.T) {
+	f, socket := startFake(t)
+	f.update(func() { f.tree = testTree })
+
+	windows, err := NewWithSocket("Sway", socket).ListWindows()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(windows, testWindows) {
+		t.Errorf("windows\n%+v\nwant\n%+v", windows, testWindows)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	f, socket := startFake(t)
+	s := NewWithSocket("Sway", socket)
+
+	if err := s.SwitchWorkspace(3); err != nil {
+		t.Errorf("switching workspace returned error: %v", err)
+	}
+
+	// Sway replies with a result for each command, and only the second failed
+	f.update(func() {
+		f.commandReply = `[{"success": true}, {"success": false, "error": "No matching node"}]`
+	})
+
+	err := s.MoveWindowToWorkspace(4)
+	if err == nil || !strings.Contains(err.Error(), "No matching node") {
+		t.Errorf("moving a window returned %v, want an error with the reason from Sway", err)
+	}
+
+	want := []string{
+		"workspace number 3",
+		"move container to workspace number 4; workspace number 4",
+	}
+	f.update(func() {
+		if !reflect.DeepEqual(f.commands, want) {
+			t.Errorf("sent commands %q, want %q", f.commands, want)
+		}
+	})
+}
+
+func TestEventStream(t *testing.T) {
+	f, socket := startFake(t)
+	f.update(func() {
+		f.tree = testTree
+		f.workspaces = testWorkspaces
+	})
+
+	stream, err := NewWithSocket("Sway", socket).EventStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	events := <-f.events
+
+	if want := []string{`["window","workspace"]`}; !reflect.DeepEqual(f.subscribed, want) {
+		t.Errorf("subscribed with %q, want %q", f.subscribed, want)
+	}
+
+	send := func(msgType uint32, payload string) {
+		t.Helper()
+		if err := writeMessage(events, msgType, []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(eventWindow, `{"change": "focus", "container": {"id": 2, "type": "con", "app_id": "foot"}}`)
+	send(eventWindow, `{"change": "close", "container": {"id": 5, "type": "con", "app_id": "code"}}`)
+
+	// Binding mode changes aren't subscribed to, and are skipped if sent
+	send(0x80000002, `{"change": "resize"}`)
+	send(eventWindow, `{"change": "new", "container": {"id": 7, "type": "con", "app_id": "foot"}}`)
+	send(eventWorkspace, `{"change": "focus", "current": {"id": 20, "type": "workspace"}}`)
+
+	focused := uint64(2)
+	want := []compositor.Event{
+		// The stream starts with the full lists, like Niri's
+		{WindowsChanged: &compositor.WindowsChanged{Windows: testWindows}},
+		{WorkspacesChanged: &compositor.WorkspacesChanged{Workspaces: testWorkspaceList}},
+
+		{WindowFocusChanged: &compositor.WindowFocusChanged{ID: &focused}},
+		{WindowClosed: &compositor.WindowClosed{ID: 5}},
+
+		// New windows and workspace changes are reported by listing them again
+		{WindowsChanged: &compositor.WindowsChanged{Windows: testWindows}},
+		{WorkspacesChanged: &compositor.WorkspacesChanged{Workspaces: testWorkspaceList}},
+	}
+
+	for i, w := range want {
+		got, err := stream.Next()
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("event %d is %+v, want %+v", i, got, w)
+		}
+	}
+}
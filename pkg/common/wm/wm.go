@@ -0,0 +1,207 @@
+package wm
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jplein/launchit/pkg/common/compositor"
+	"github.com/jplein/launchit/pkg/common/hyprland"
+	"github.com/jplein/launchit/pkg/common/logger"
+	"github.com/jplein/launchit/pkg/common/niri"
+	"github.com/jplein/launchit/pkg/common/server/client"
+	"github.com/jplein/launchit/pkg/common/sway"
+)
+
+var (
+	current    compositor.Compositor
+	currentErr error
+	detectOnce sync.Once
+)
+
+// A local copy of the compositor's state, used by `launchit server` so that
+// it doesn't query itself over HTTP
+type Snapshot interface {
+	// Returns the open windows, most recently focused first, or false if they
+	// aren't known yet
+	Windows() ([]compositor.Window, bool)
+
+	// Returns the workspaces, or false if they aren't known yet
+	Workspaces() ([]compositor.Workspace, bool)
+}
+
+var local Snapshot
+
+// Answer window and workspace queries from s instead of from the server
+func UseSnapshot(s Snapshot) {
+	local = s
+}
+
+// Returns the running compositor, detecting it on the first call
+func Current() (compositor.Compositor, error) {
+	detectOnce.Do(func() {
+		current, currentErr = Detect()
+	})
+
+	return current, currentErr
+}
+
+// Detect the running compositor from the environment it sets for its clients
+func Detect() (compositor.Compositor, error) {
+	if os.Getenv("NIRI_SOCKET") != "" {
+		return newNiri()
+	}
+
+	if os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != "" {
+		return newHyprland()
+	}
+
+	if os.Getenv("SWAYSOCK") != "" || os.Getenv("I3SOCK") != "" {
+		return newSway()
+	}
+
+	// Fall back to the desktop name, for sessions where the socket variables
+	// weren't passed through (e.g., some systemd user services)
+	for _, desktop := range strings.Split(os.Getenv("XDG_CURRENT_DESKTOP"), ":") {
+		switch strings.ToLower(desktop) {
+		case "niri":
+			return newNiri()
+		case "hyprland":
+			return newHyprland()
+		case "sway", "i3":
+			return newSway()
+		}
+	}
+
+	return nil, errors.New("error detecting compositor: no supported compositor found (set NIRI_SOCKET, HYPRLAND_INSTANCE_SIGNATURE, SWAYSOCK or I3SOCK)")
+}
+
+func newNiri() (compositor.Compositor, error) {
+	n, err := niri.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func newHyprland() (compositor.Compositor, error) {
+	h, err := hyprland.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func newSway() (compositor.Compositor, error) {
+	s, err := sway.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// List windows of the running compositor. If `launchit server` is running,
+// its copy of the window list is used, and the compositor isn't queried.
+//
+// sortWindows: If true, windows are sorted with the most recently focused
+// first, using the focus history kept by `launchit server`. Windows from the
+// server are always sorted.
+func ListWindows(sortWindows bool) ([]compositor.Window, error) {
+	if local != nil {
+		if windows, ok := local.Windows(); ok {
+			return windows, nil
+		}
+	} else {
+		windows, err := client.Windows()
+		if err == nil {
+			return windows, nil
+		}
+		logger.Log("error getting windows from server, asking the compositor: %v\n", err)
+	}
+
+	c, err := Current()
+	if err != nil {
+		return nil, err
+	}
+
+	windows, err := c.ListWindows()
+	if err != nil {
+		return nil, err
+	}
+
+	if sortWindows && local == nil {
+		history := []uint64{}
+		serverHistory, err := client.History()
+		if err != nil {
+			logger.Log("error getting history from server: %v\n", err)
+		} else {
+			history = serverHistory
+		}
+
+		sortWindowsByHistory(windows, history)
+	}
+
+	return windows, nil
+}
+
+// List workspaces of the running compositor. If `launchit server` is running,
+// its copy of the workspace list is used, and the compositor isn't queried.
+func ListWorkspaces() ([]compositor.Workspace, error) {
+	if local != nil {
+		if workspaces, ok := local.Workspaces(); ok {
+			return workspaces, nil
+		}
+	} else {
+		workspaces, err := client.Workspaces()
+		if err == nil {
+			return workspaces, nil
+		}
+		logger.Log("error getting workspaces from server, asking the compositor: %v\n", err)
+	}
+
+	c, err := Current()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ListWorkspaces()
+}
+
+// Sort a list of windows in place, with the most recent windows appearing first
+//
+// windows: A list of windows
+// history: A list of window IDs, ordered so that the most recent windows are at the end
+func sortWindowsByHistory(windows []compositor.Window, history []uint64) {
+	// Create a map from window ID to its position in history
+	historyPos := make(map[uint64]int)
+	for i, id := range history {
+		historyPos[id] = i
+	}
+
+	// Sort windows: most recently focused first
+	sort.SliceStable(windows, func(i, j int) bool {
+		posI, inHistoryI := historyPos[windows[i].ID]
+		posJ, inHistoryJ := historyPos[windows[j].ID]
+
+		// If both are in history, sort by position (higher = more recent = comes first)
+		if inHistoryI && inHistoryJ {
+			return posI > posJ
+		}
+
+		// If only one is in history, it comes first
+		if inHistoryI {
+			return true
+		}
+		if inHistoryJ {
+			return false
+		}
+
+		// If neither is in history, maintain original order (stable sort handles this)
+		return false
+	})
+}
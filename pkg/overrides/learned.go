@@ -0,0 +1,170 @@
+package overrides
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jplein/launchit/pkg/common/state"
+	"github.com/jplein/launchit/pkg/common/state/locations"
+)
+
+// An override learned by the server, from a window that opened shortly after
+// an application was launched and that didn't match any application
+type Learned struct {
+	Override
+
+	// When the pairing was seen
+	LearnedAt time.Time `json:"learned-at"`
+
+	// Whether the user rejected the pairing. Rejected pairings aren't used,
+	// and aren't learned again.
+	Rejected bool `json:"rejected,omitempty"`
+}
+
+type learnedDoc struct {
+	Learned []Learned `json:"learned"`
+}
+
+// Returns the learned overrides, including rejected ones, ordered by window
+// app ID
+func ListLearned() ([]Learned, error) {
+	file, err := locations.LearnedOverridesFilename()
+	if err != nil {
+		return nil, fmt.Errorf("error getting learned overrides: %w", err)
+	}
+
+	return readLearned(file)
+}
+
+func readLearned(file string) ([]Learned, error) {
+	buf, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Learned{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading learned overrides from %s: %w", file, err)
+	}
+
+	var doc learnedDoc
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("error reading learned overrides from %s: %w", file, err)
+	}
+
+	sort.Slice(doc.Learned, func(i, j int) bool {
+		return doc.Learned[i].WindowAppID < doc.Learned[j].WindowAppID
+	})
+
+	return doc.Learned, nil
+}
+
+// Read the learned overrides, let f change them, and write them back, holding
+// the lock so that the server and the overrides command don't overwrite each
+// other's changes
+func updateLearned(f func(learned []Learned) ([]Learned, error)) error {
+	file, err := locations.LearnedOverridesFilename()
+	if err != nil {
+		return fmt.Errorf("error getting learned overrides: %w", err)
+	}
+
+	return state.WithLock(file, func() error {
+		learned, err := readLearned(file)
+		if err != nil {
+			return err
+		}
+
+		learned, err = f(learned)
+		if err != nil {
+			return err
+		}
+
+		buf, err := json.MarshalIndent(learnedDoc{Learned: learned}, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error writing learned overrides: %w", err)
+		}
+
+		return state.WriteFileAtomic(file, buf, locations.DefaultFilePermission)
+	})
+}
+
+// Record that windows with the given app ID belong to the application. Returns
+// false if the window app ID already has a learned or rejected override, in
+// which case nothing changes.
+//
+// appID: The desktop file ID of the application, without .desktop
+//
+// windowAppID: The app ID of its windows, as reported by the window manager
+func Learn(appID string, windowAppID string) (bool, error) {
+	added := false
+
+	err := updateLearned(func(learned []Learned) ([]Learned, error) {
+		for _, l := range learned {
+			if l.WindowAppID == windowAppID {
+				return learned, nil
+			}
+		}
+
+		added = true
+		return append(learned, Learned{
+			Override:  Override{AppID: appID, WindowAppID: windowAppID},
+			LearnedAt: time.Now(),
+		}), nil
+	})
+
+	return added, err
+}
+
+// Mark the learned override for the window app ID as rejected, so that it is
+// no longer used or learned again
+func Reject(windowAppID string) error {
+	return updateLearned(func(learned []Learned) ([]Learned, error) {
+		for i := range learned {
+			if learned[i].WindowAppID == windowAppID {
+				learned[i].Rejected = true
+				return learned, nil
+			}
+		}
+
+		return nil, fmt.Errorf("no learned override for window app ID %s", windowAppID)
+	})
+}
+
+// Move the learned override for the window app ID to the overrides file, where
+// it can be edited like the overrides written by hand
+func Promote(windowAppID string) error {
+	return updateLearned(func(learned []Learned) ([]Learned, error) {
+		for i, l := range learned {
+			if l.WindowAppID != windowAppID {
+				continue
+			}
+
+			if err := add(l.Override); err != nil {
+				return nil, err
+			}
+
+			return append(learned[:i], learned[i+1:]...), nil
+		}
+
+		return nil, fmt.Errorf("no learned override for window app ID %s", windowAppID)
+	})
+}
+
+// Returns the learned overrides that haven't been rejected
+func activeLearned() ([]Override, error) {
+	learned, err := ListLearned()
+	if err != nil {
+		return nil, err
+	}
+
+	active := make([]Override, 0, len(learned))
+	for _, l := range learned {
+		if !l.Rejected {
+			active = append(active, l.Override)
+		}
+	}
+
+	return active, nil
+}
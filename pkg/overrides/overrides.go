@@ -1,20 +1,23 @@
 package overrides
 
 import (
+	"bytes"
 	_ "embed"
+	"errors"
 	"fmt"
 	"os"
 
+	"github.com/jplein/launchit/pkg/common/state"
 	"github.com/jplein/launchit/pkg/common/state/locations"
 	"go.yaml.in/yaml/v4"
 )
 
 type Override struct {
 	// The basename of the .desktop file
-	AppID string `yaml:"app-id"`
+	AppID string `yaml:"app-id" json:"app-id"`
 
 	// The application ID as returned by Niri or another window manager for windows of this application
-	WindowAppID string `yaml:"window-app-id"`
+	WindowAppID string `yaml:"window-app-id" json:"window-app-id"`
 }
 
 type overridesDoc struct {
@@ -29,10 +32,24 @@ const (
 	overridesFile = "overrides.yaml"
 )
 
-func getOverrides() ([]Override, error) {
+func overridesFilename() (string, error) {
 	overridesPath, err := locations.Initialize(locations.XDGConfigDir, overridesFile, overridesBuf, locations.DefaultFilePermission)
 	if err != nil {
-		return nil, fmt.Errorf("error getting overrides: %w", err)
+		return "", fmt.Errorf("error getting overrides: %w", err)
+	}
+
+	return overridesPath, nil
+}
+
+// Returns the overrides from the overrides file, without the learned ones
+func List() ([]Override, error) {
+	return getOverrides()
+}
+
+func getOverrides() ([]Override, error) {
+	overridesPath, err := overridesFilename()
+	if err != nil {
+		return nil, err
 	}
 
 	buf, err := os.ReadFile(overridesPath)
@@ -50,32 +67,186 @@ func getOverrides() ([]Override, error) {
 	return doc.Overrides, nil
 }
 
-func ByAppID(appID string) (*Override, error) {
+// Returns all overrides in effect: those in the overrides file, then the
+// learned ones that haven't been rejected
+func allOverrides() ([]Override, error) {
 	overrides, err := getOverrides()
 	if err != nil {
 		return nil, err
 	}
 
+	learned, err := activeLearned()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(overrides, learned...), nil
+}
+
+// The overrides in effect, indexed so that many applications or windows can be
+// looked up without reading the files again for each
+type Set struct {
+	byAppID       map[string]Override
+	byWindowAppID map[string]Override
+}
+
+// Read the overrides in effect: those in the overrides file, then the learned
+// ones that haven't been rejected. Where several match, the first wins.
+func Load() (*Set, error) {
+	overrides, err := allOverrides()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Set{
+		byAppID:       make(map[string]Override, len(overrides)),
+		byWindowAppID: make(map[string]Override, len(overrides)),
+	}
+
 	for _, o := range overrides {
-		if o.AppID == appID {
-			return &o, nil
+		if _, ok := s.byAppID[o.AppID]; !ok {
+			s.byAppID[o.AppID] = o
 		}
+		if _, ok := s.byWindowAppID[o.WindowAppID]; !ok {
+			s.byWindowAppID[o.WindowAppID] = o
+		}
+	}
+
+	return s, nil
+}
+
+// Returns the override for the application, or nil if there is none or the
+// set is nil
+func (s *Set) ByAppID(appID string) *Override {
+	if s == nil {
+		return nil
+	}
+
+	if o, ok := s.byAppID[appID]; ok {
+		return &o
+	}
+
+	return nil
+}
+
+// Returns the override for windows with the app ID, or nil if there is none or
+// the set is nil
+func (s *Set) ByWindowAppID(windowAppID string) *Override {
+	if s == nil {
+		return nil
+	}
+
+	if o, ok := s.byWindowAppID[windowAppID]; ok {
+		return &o
+	}
+
+	return nil
+}
+
+// Returns the override for the application, from the overrides file or else
+// learned, or nil if there is none
+func ByAppID(appID string) (*Override, error) {
+	s, err := Load()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, nil
+	return s.ByAppID(appID), nil
 }
 
+// Returns the override for windows with the app ID, from the overrides file or
+// else learned, or nil if there is none
 func ByWindowAppID(windowID string) (*Override, error) {
-	overrides, err := getOverrides()
+	s, err := Load()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, o := range overrides {
-		if o.WindowAppID == windowID {
-			return &o, nil
+	return s.ByWindowAppID(windowID), nil
+}
+
+// Append an override to the overrides file, keeping the rest of the file,
+// comments included, as it is. The file is locked while it is rewritten, so
+// that concurrent additions aren't lost.
+func add(o Override) error {
+	overridesPath, err := overridesFilename()
+	if err != nil {
+		return err
+	}
+
+	return state.WithLock(overridesPath, func() error {
+		buf, err := os.ReadFile(overridesPath)
+		if err != nil {
+			return fmt.Errorf("error adding override: error reading from %s: %w", overridesPath, err)
+		}
+
+		out, err := appendOverride(buf, o)
+		if err != nil {
+			return fmt.Errorf("error adding override to %s: %w", overridesPath, err)
 		}
+
+		if err := state.WriteFileAtomic(overridesPath, out, locations.DefaultFilePermission); err != nil {
+			return fmt.Errorf("error adding override: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// Returns the overrides file with an override appended to its list
+func appendOverride(buf []byte, o Override) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing YAML: %w", err)
+	}
+
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("the file doesn't contain a mapping")
+	}
+
+	var list *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "overrides" {
+			list = root.Content[i+1]
+		}
+	}
+
+	if list == nil || list.Kind != yaml.SequenceNode {
+		// Replace a missing or empty (null) list
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: "overrides"}
+		value := &yaml.Node{Kind: yaml.SequenceNode}
+		if list != nil {
+			*list = *value
+			value = list
+		} else {
+			root.Content = append(root.Content, key, value)
+		}
+		list = value
+	}
+
+	var entry yaml.Node
+	if err := entry.Encode(o); err != nil {
+		return nil, err
+	}
+
+	// Write the list in block style, even if it was written as []
+	list.Style = 0
+	list.Content = append(list.Content, &entry)
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
 	}
 
-	return nil, nil
+	return out.Bytes(), nil
 }
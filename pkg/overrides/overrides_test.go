@@ -0,0 +1,94 @@
+package overrides
+
+import "testing"
+
+func TestLoad(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	// The default overrides file maps google-chrome to com.google.Chrome
+	for _, l := range []Override{
+		{AppID: "org.example.Editor", WindowAppID: "editor-bin"},
+		{AppID: "com.google.Chrome", WindowAppID: "chrome-beta"},
+		{AppID: "org.example.Rejected", WindowAppID: "rejected"},
+	} {
+		if _, err := Learn(l.AppID, l.WindowAppID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Reject("rejected"); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byAppID := map[string]string{
+		// The overrides file comes before the learned overrides
+		"com.google.Chrome":    "google-chrome",
+		"org.example.Editor":   "editor-bin",
+		"org.example.Rejected": "",
+		"org.example.Missing":  "",
+	}
+	for appID, want := range byAppID {
+		got := ""
+		if o := s.ByAppID(appID); o != nil {
+			got = o.WindowAppID
+		}
+		if got != want {
+			t.Errorf("ByAppID(%s) has window app ID %q, want %q", appID, got, want)
+		}
+	}
+
+	byWindowAppID := map[string]string{
+		"google-chrome": "com.google.Chrome",
+		"chrome-beta":   "com.google.Chrome",
+		"editor-bin":    "org.example.Editor",
+		"rejected":      "",
+	}
+	for windowAppID, want := range byWindowAppID {
+		got := ""
+		if o := s.ByWindowAppID(windowAppID); o != nil {
+			got = o.AppID
+		}
+		if got != want {
+			t.Errorf("ByWindowAppID(%s) has app ID %q, want %q", windowAppID, got, want)
+		}
+	}
+
+	var none *Set
+	if o := none.ByAppID("com.google.Chrome"); o != nil {
+		t.Errorf("nil set returned override %+v", o)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	if _, err := Learn("org.example.Editor", "editor-bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Promote("editor-bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	overrides, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Override{AppID: "org.example.Editor", WindowAppID: "editor-bin"}
+	if len(overrides) == 0 || overrides[len(overrides)-1] != want {
+		t.Errorf("overrides file has %+v, want it to end with %+v", overrides, want)
+	}
+
+	learned, err := ListLearned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(learned) != 0 {
+		t.Errorf("learned overrides %+v after promoting, want none", learned)
+	}
+}